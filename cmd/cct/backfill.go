@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// backfillSummary keeps track of how fetching each day in an interval went for one provider
+type backfillSummary struct {
+	provider   string
+	fetched    int
+	skipped    int
+	failed     int
+	failedDays []string
+}
+
+// Counts the result of fetching one day
+func (summary *backfillSummary) add(result fetchResult, date time.Time) {
+	if result.err != nil {
+		summary.failed++
+		summary.failedDays = append(summary.failedDays, date.Format(dateFormat))
+	} else if result.count == 0 {
+		summary.skipped++
+	} else {
+		summary.fetched++
+	}
+}
+
+// Logs the summaries
+func logSummaries(summaries []backfillSummary) {
+	for _, summary := range summaries {
+		log.Printf("%s: fetched %d, skipped %d and failed %d days",
+			summary.provider, summary.fetched, summary.skipped, summary.failed)
+	}
+}
+
+// Fetches the cost for an interval of days and returns the exit code
+func runBackfill(args []string) int {
+	flags := flag.NewFlagSet("cct backfill", flag.ExitOnError)
+	addCommonFlags(flags)
+	from := flags.String("from", "", "The first day to fetch, formatted as YYYY-MM-DD.")
+	to := flags.String("to", "", "The last day to fetch, formatted as YYYY-MM-DD. Defaults to today.")
+	flags.Parse(args)
+
+	startDate, stopDate, err := parseInterval(*from, *to, time.Now())
+	if err != nil {
+		log.Println("Backfill:", err)
+		flags.Usage()
+		return 2
+	}
+
+	cfg := mustLoadConfig(flags)
+	db := newDBClient(cfg.Database)
+	providers := getProviders(cfg)
+
+	ctx, cancel := contextWithSignals()
+	defer cancel()
+
+	startTime := time.Now()
+	summaries, err := fetchDataForInterval(ctx, &db, providers, startDate, stopDate)
+	stopTime := time.Now()
+
+	logSummaries(summaries)
+	log.Println("Done! Fetched the data in", stopTime.Sub(startTime))
+
+	if err != nil {
+		log.Println("Backfill failed:", err)
+		return 1
+	}
+	return 0
+}
+
+// Parses and validates the interval given to backfill.
+// An empty stop date means today.
+func parseInterval(from, to string, now time.Time) (time.Time, time.Time, error) {
+	if from == "" {
+		return time.Time{}, time.Time{}, errors.New("a start date must be given with --from")
+	}
+
+	startDate, err := time.ParseInLocation(dateFormat, from, now.Location())
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start date %q: %v", from, err)
+	}
+
+	stopDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if to != "" {
+		stopDate, err = time.ParseInLocation(dateFormat, to, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid stop date %q: %v", to, err)
+		}
+	}
+
+	if startDate.After(stopDate) {
+		return time.Time{}, time.Time{}, errors.New("start date can't be after stop date")
+	}
+	if stopDate.After(now) {
+		return time.Time{}, time.Time{}, errors.New("stop date can't be in the future")
+	}
+
+	return startDate, stopDate, nil
+}
+
+// Returns the number of calendar days from the start to the stop date, both included.
+// The dates are compared in UTC since a local day can be 23 or 25 hours long.
+func daysInInterval(startDate time.Time, stopDate time.Time) int {
+	start := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, time.UTC)
+	stop := time.Date(stopDate.Year(), stopDate.Month(), stopDate.Day(), 0, 0, 0, 0, time.UTC)
+	return int(stop.Sub(start).Hours()/24) + 1
+}
+
+// Fetches data from the providers for an interval and adding it to the database.
+// Both the start and the stop date are included. Stops early if the context is done.
+// The summaries are in the same order as the providers. Returns an error naming the days that failed
+// for each provider, and if the interval was interrupted.
+func fetchDataForInterval(ctx context.Context, db usageWriter, providers []provider, startDate time.Time, stopDate time.Time) ([]backfillSummary, error) {
+	summaries := make([]backfillSummary, len(providers))
+	for i, p := range providers {
+		summaries[i].provider = p.name
+	}
+
+	currentTime := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, startDate.Location())
+	// Make sure stop date will be included
+	stopDate = time.Date(stopDate.Year(), stopDate.Month(), stopDate.Day(), 0, 0, 0, 1, stopDate.Location())
+
+	days := daysInInterval(startDate, stopDate)
+	interrupted := false
+	for day := 1; currentTime.Before(stopDate); day++ {
+		if ctx.Err() != nil {
+			log.Println("Interrupted, stopping before", currentTime.Format(dateFormat))
+			interrupted = true
+			break
+		}
+
+		results := fetchDataForDate(ctx, db, providers, currentTime)
+		for i, result := range results {
+			progress := fmt.Sprintf("[%d/%d] %s %s:", day, days, currentTime.Format(dateFormat), result.provider)
+			if result.err != nil {
+				log.Println(progress, "failed:", result.err)
+				if result.count > 0 {
+					log.Println(progress, "added", result.count, "usage data anyway")
+				}
+			} else if result.count == 0 {
+				log.Println(progress, "no usage data, skipping")
+			} else {
+				log.Println(progress, "added", result.count, "usage data")
+			}
+			summaries[i].add(result, currentTime)
+		}
+
+		currentTime = currentTime.AddDate(0, 0, 1)
+	}
+
+	var problems []string
+	for _, summary := range summaries {
+		if len(summary.failedDays) > 0 {
+			problems = append(problems, fmt.Sprintf("%s failed on %s", summary.provider, strings.Join(summary.failedDays, ", ")))
+		}
+	}
+	if interrupted {
+		problems = append(problems, "interrupted before "+currentTime.Format(dateFormat))
+	}
+	if len(problems) > 0 {
+		return summaries, errors.New(strings.Join(problems, "; "))
+	}
+	return summaries, nil
+}
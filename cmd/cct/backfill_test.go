@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+)
+
+func TestParseInterval(t *testing.T) {
+	now := time.Date(2018, time.August, 10, 15, 30, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		from  string
+		to    string
+		start time.Time
+		stop  time.Time
+		valid bool
+	}{
+		{"Interval", "2018-08-01", "2018-08-05", time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, time.August, 5, 0, 0, 0, 0, time.UTC), true},
+		{"Stop date defaults to today", "2018-08-01", "", time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC), true},
+		{"Single day", "2018-08-10", "2018-08-10", time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC), time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC), true},
+		{"Missing start date", "", "2018-08-05", time.Time{}, time.Time{}, false},
+		{"Malformed start date", "2018-8-1", "", time.Time{}, time.Time{}, false},
+		{"Malformed stop date", "2018-08-01", "yesterday", time.Time{}, time.Time{}, false},
+		{"Reversed interval", "2018-08-05", "2018-08-01", time.Time{}, time.Time{}, false},
+		{"Stop date in the future", "2018-08-01", "2018-08-11", time.Time{}, time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start, stop, err := parseInterval(c.from, c.to, now)
+			if !c.valid {
+				if err == nil {
+					t.Errorf("Expected error but got none!")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Caught error: %s", err)
+			}
+			if !start.Equal(c.start) || !stop.Equal(c.stop) {
+				t.Errorf("Expected %v to %v but got %v to %v", c.start, c.stop, start, stop)
+			}
+		})
+	}
+}
+
+func TestDaysInInterval(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	// March 2018 has 30 days after the 1st, and the clocks move forward on the 25th
+	start := time.Date(2018, time.March, 1, 0, 0, 0, 0, stockholm)
+	stop := time.Date(2018, time.March, 31, 0, 0, 0, 0, stockholm)
+	if days := daysInInterval(start, stop); days != 31 {
+		t.Errorf("Expected 31 days but got %d", days)
+	}
+	if days := daysInInterval(stop, stop); days != 1 {
+		t.Errorf("Expected 1 day but got %d", days)
+	}
+}
+
+func TestBackfillSummaryAdd(t *testing.T) {
+	summary := backfillSummary{provider: "aws"}
+	results := []fetchResult{
+		{provider: "aws", count: 3},
+		{provider: "aws", count: 0},
+		{provider: "aws", err: errors.New("error")},
+		// Partial data that was written is still a failed day
+		{provider: "aws", count: 2, err: errors.New("error")},
+		{provider: "aws", count: 1},
+	}
+	date := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	for i, result := range results {
+		summary.add(result, date.AddDate(0, 0, i))
+	}
+
+	if summary.fetched != 2 || summary.skipped != 1 || summary.failed != 2 {
+		t.Errorf("Expected 2 fetched, 1 skipped and 2 failed days but got %+v", summary)
+	}
+	if strings.Join(summary.failedDays, ",") != "2018-08-03,2018-08-04" {
+		t.Errorf("Expected the failed days 2018-08-03 and 2018-08-04 but got %v", summary.failedDays)
+	}
+}
+
+// fakeDailyCloudCost returns one UsageData for every day, except the days that fail
+type fakeDailyCloudCost struct {
+	failedDays map[string]bool
+}
+
+func (f fakeDailyCloudCost) GetCloudCost(ctx context.Context, date time.Time) ([]dbclient.UsageData, error) {
+	if f.failedDays[date.Format(dateFormat)] {
+		return nil, errors.New("error")
+	}
+	return []dbclient.UsageData{{Cost: 1, Date: date}}, nil
+}
+
+func TestFetchDataForInterval(t *testing.T) {
+	start := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	stop := time.Date(2018, time.August, 3, 0, 0, 0, 0, time.UTC)
+
+	t.Run("All days", func(t *testing.T) {
+		db := &fakeWriter{}
+		providers := []provider{{name: "aws", CloudCostClient: fakeDailyCloudCost{}}}
+		summaries, err := fetchDataForInterval(context.Background(), db, providers, start, stop)
+		if err != nil {
+			t.Errorf("Caught error: %s", err)
+		}
+		if len(summaries) != 1 || summaries[0].fetched != 3 || len(db.written) != 3 {
+			t.Errorf("Expected 3 fetched days but got %+v", summaries)
+		}
+	})
+
+	t.Run("Failed days", func(t *testing.T) {
+		db := &fakeWriter{}
+		providers := []provider{
+			{name: "aws", CloudCostClient: fakeDailyCloudCost{failedDays: map[string]bool{"2018-08-02": true}}},
+			{name: "azure", CloudCostClient: fakeDailyCloudCost{failedDays: map[string]bool{"2018-08-01": true, "2018-08-03": true}}},
+		}
+		summaries, err := fetchDataForInterval(context.Background(), db, providers, start, stop)
+		if err == nil {
+			t.Fatalf("Expected error but got none!")
+		}
+		expected := "aws failed on 2018-08-02; azure failed on 2018-08-01, 2018-08-03"
+		if err.Error() != expected {
+			t.Errorf("Expected error %q but got %q", expected, err)
+		}
+		if summaries[0].fetched != 2 || summaries[1].fetched != 1 || len(db.written) != 3 {
+			t.Errorf("Expected the other days to be fetched but got %+v", summaries)
+		}
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		providers := []provider{{name: "aws", CloudCostClient: fakeDailyCloudCost{}}}
+		_, err := fetchDataForInterval(ctx, &fakeWriter{}, providers, start, stop)
+		if err == nil || !strings.Contains(err.Error(), "interrupted before 2018-08-01") {
+			t.Errorf("Expected an interrupted error but got %v", err)
+		}
+	})
+}
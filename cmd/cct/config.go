@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/config"
+)
+
+// Registers the flags that are shared by all commands.
+// The flags override the configuration file and the environment, but only if they are set.
+func addCommonFlags(flags *flag.FlagSet) {
+	defaults := config.Default()
+	flags.String("config", "", "Path to a YAML configuration file. Defaults to $CCT_CONFIG.")
+	flags.String("cloud", "", "Comma separated list of the cloud providers you want to update, or \"all\". Defaults to all configured providers.")
+	flags.String("db-name", defaults.Database.Name, "The name of the database to use.")
+	flags.String("db-username", defaults.Database.Username, "The username to the database.")
+	flags.String("db-password", defaults.Database.Password, "The password to the database.")
+	flags.String("db-address", defaults.Database.Address, "The address to the database.")
+}
+
+// Reads the configuration file and applies the environment and the flags that are set.
+// Returns every problem with the resulting configuration.
+func loadConfig(flags *flag.FlagSet) (config.Config, []error) {
+	cfg := config.Default()
+
+	path := flags.Lookup("config").Value.String()
+	if path == "" {
+		path = os.Getenv("CCT_CONFIG")
+	}
+	if path != "" {
+		var err error
+		if cfg, err = config.Load(path); err != nil {
+			return cfg, []error{fmt.Errorf("unable to read %s: %v", path, err)}
+		}
+	}
+
+	errs := cfg.ApplyEnvironment(os.LookupEnv)
+
+	flags.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "cloud":
+			cfg.Clouds = config.SplitClouds(value)
+		case "db-name":
+			cfg.Database.Name = value
+		case "db-username":
+			cfg.Database.Username = value
+		case "db-password":
+			cfg.Database.Password = value
+		case "db-address":
+			cfg.Database.Address = value
+		case "schedule":
+			cfg.Schedule.Cron = value
+		case "interval":
+			cfg.Schedule.Interval = f.Value.(flag.Getter).Get().(time.Duration)
+		case "window":
+			cfg.Schedule.Window = f.Value.(flag.Getter).Get().(int)
+		}
+	})
+
+	return cfg, append(errs, cfg.Validate()...)
+}
+
+// Loads the configuration and exits if there are any problems with it
+func mustLoadConfig(flags *flag.FlagSet) config.Config {
+	cfg, errs := loadConfig(flags)
+	if len(errs) > 0 {
+		logConfigErrors(errs)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+func logConfigErrors(errs []error) {
+	log.Println("Found", len(errs), "problems with the configuration:")
+	for _, err := range errs {
+		log.Println("  ", err)
+	}
+}
+
+// Handles the config command and returns the exit code
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		log.Println("Usage: cct config validate [flags]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("cct config validate", flag.ExitOnError)
+	addCommonFlags(flags)
+	addServeFlags(flags)
+	flags.Parse(args[1:])
+
+	if _, errs := loadConfig(flags); len(errs) > 0 {
+		logConfigErrors(errs)
+		return 1
+	}
+
+	log.Println("The configuration is valid")
+	return 0
+}
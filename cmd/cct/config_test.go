@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+database:
+  address: http://influxdb:8086
+  name: file-db
+  username: file-user
+azure:
+  - name: azure
+schedule:
+  interval: 6h
+`
+
+// Writes the configuration to a file in a temporary directory and returns the path
+func writeTestConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "cct.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	return path
+}
+
+// Creates the flags of the serve command and parses the arguments
+func parseTestFlags(t *testing.T, args ...string) *flag.FlagSet {
+	flags := flag.NewFlagSet("cct serve", flag.ContinueOnError)
+	addCommonFlags(flags)
+	addServeFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	return flags
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	t.Setenv("CCT_CONFIG", writeTestConfig(t, testConfig))
+	t.Setenv("CCT_DB_NAME", "env-db")
+	t.Setenv("CCT_DB_USERNAME", "env-user")
+
+	// Flags override the environment, which overrides the file
+	cfg, errs := loadConfig(parseTestFlags(t, "--db-name", "flag-db", "--window", "5"))
+	if len(errs) != 0 {
+		t.Fatalf("Expected no problems but got %v", errs)
+	}
+	if cfg.Database.Name != "flag-db" {
+		t.Errorf("Expected the database name of the flag but got %s", cfg.Database.Name)
+	}
+	if cfg.Database.Username != "env-user" {
+		t.Errorf("Expected the username of the environment but got %s", cfg.Database.Username)
+	}
+	if cfg.Database.Address != "http://influxdb:8086" {
+		t.Errorf("Expected the address of the file but got %s", cfg.Database.Address)
+	}
+	// Flags that aren't set keep the value of the file, even if they have a default
+	if cfg.Schedule.Interval != 6*time.Hour || cfg.Schedule.Window != 5 {
+		t.Errorf("Expected an interval of 6h and a window of 5 days but got %+v", cfg.Schedule)
+	}
+}
+
+func TestLoadConfigFlagPath(t *testing.T) {
+	t.Setenv("CCT_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	cfg, errs := loadConfig(parseTestFlags(t, "--config", writeTestConfig(t, testConfig)))
+	if len(errs) != 0 {
+		t.Fatalf("Expected no problems but got %v", errs)
+	}
+	if cfg.Database.Name != "file-db" {
+		t.Errorf("Expected the database name of the file but got %s", cfg.Database.Name)
+	}
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	t.Setenv("CCT_CONFIG", writeTestConfig(t, testConfig))
+	t.Setenv("CCT_WINDOW", "week")
+
+	// One problem for each of: the window of the environment, the window being zero
+	// and the schedule of the flag together with the interval of the file
+	_, errs := loadConfig(parseTestFlags(t, "--schedule", "@daily"))
+	if len(errs) != 3 {
+		t.Errorf("Expected 3 problems but got %v", errs)
+	}
+
+	t.Setenv("CCT_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, errs := loadConfig(parseTestFlags(t)); len(errs) != 1 {
+		t.Errorf("Expected a problem with the missing file but got %v", errs)
+	}
+}
+
+func TestLoadConfigWithoutProviders(t *testing.T) {
+	// Without a file no providers are configured, even if a cloud is selected
+	t.Setenv("CCT_CONFIG", "")
+	cfg, errs := loadConfig(parseTestFlags(t, "--cloud", "aws"))
+	if len(cfg.AWS) != 0 || len(cfg.Azure) != 0 {
+		t.Errorf("Expected no providers but got %+v and %+v", cfg.AWS, cfg.Azure)
+	}
+	if len(errs) != 2 {
+		t.Errorf("Expected problems with the selected cloud and the missing providers but got %v", errs)
+	}
+}
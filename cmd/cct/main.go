@@ -1,23 +1,43 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lentzi90/cloud-cost-tracker/internal/cct/aws"
 	"github.com/lentzi90/cloud-cost-tracker/internal/cct/azure"
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/config"
 	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
 )
 
-var (
-	cloud      = flag.String("cloud", "", "The cloud provider you want to update.")
-	dbName     = flag.String("db-name", "cloudCostTracker", "The name of the database to use.")
-	dbUsername = flag.String("db-username", "cctUser", "The username to the database.")
-	dbPassword = flag.String("db-password", "cctPassword", "The password to the database.")
-	dbAddress  = flag.String("db-address", "http://localhost:8086", "The address to the database.")
-)
+// provider is a CloudCostClient for one configured account together with its name
+type provider struct {
+	name   string
+	labels config.LabelRules
+	// writePartial writes the data that was fetched even if the provider also returned a partial error
+	writePartial bool
+	// window is the number of days, counting today, that the daemon fetches every time
+	window int
+	dbclient.CloudCostClient
+}
+
+// fetchResult is the outcome of fetching one day from one provider.
+// The count of a failed result is the number of UsageData that was added anyway, if any.
+type fetchResult struct {
+	provider string
+	count    int
+	err      error
+}
+
+// usageWriter is the part of the DBClient that writes the data, to simplify testing
+type usageWriter interface {
+	AddUsageData(usageData []dbclient.UsageData) error
+}
 
 // Struct to be able to use the interface from dbclient with Azure
 type azureCloudCost struct {
@@ -29,82 +49,243 @@ type awsCloudCost struct {
 	*aws.Client
 }
 
+// Struct to be able to use the interface from dbclient with local AWS report files
+type awsFileCloudCost struct {
+	*aws.FileClient
+}
+
+// Struct to be able to use the interface from dbclient with the AWS Cost Explorer API
+type awsExplorerCloudCost struct {
+	*aws.ExplorerClient
+}
+
 func main() {
 	log.Println("Cloud Cost Tracker starting")
 
-	flag.Parse()
+	// The first argument selects a command unless it is a flag
+	command := ""
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	db := dbclient.NewDBClient(dbclient.Config{
-		DBName:   *dbName,
-		Username: *dbUsername,
-		Password: *dbPassword,
-		Address:  *dbAddress,
-	})
+	switch command {
+	case "":
+		os.Exit(runToday(args))
+	case "backfill":
+		os.Exit(runBackfill(args))
+	case "serve":
+		os.Exit(runServe(args))
+	case "config":
+		os.Exit(runConfig(args))
+	default:
+		log.Fatalf("Command \"%v\" is not supported", command)
+	}
+}
+
+// Fetches the cost of today, or runs as a daemon, and returns the exit code
+func runToday(args []string) int {
+	flags := flag.NewFlagSet("cct", flag.ExitOnError)
+	addCommonFlags(flags)
+	daemon := flags.Bool("daemon", false, "Keep running and fetch data on a schedule, same as the serve command.")
+	addServeFlags(flags)
+	flags.Parse(args)
+
+	cfg := mustLoadConfig(flags)
+	if *daemon {
+		return serveWithConfig(cfg)
+	}
+
+	db := newDBClient(cfg.Database)
+	providers := getProviders(cfg)
 
-	cloudCost := getCloudCostClient()
+	ctx, cancel := contextWithSignals()
+	defer cancel()
 
 	startTime := time.Now()
-	fetchDataForDate(db, cloudCost, time.Now())
+	results := fetchDataForDate(ctx, &db, providers, time.Now())
 	stopTime := time.Now()
 
+	exitCode := 0
+	for _, result := range results {
+		if result.err != nil {
+			log.Println("Failed to fetch the data for", result.provider+":", result.err)
+			if result.count > 0 {
+				log.Println("Added", result.count, "usage data for", result.provider, "anyway")
+			}
+			exitCode = 1
+		} else {
+			log.Println("Added", result.count, "usage data for", result.provider)
+		}
+	}
+
 	log.Println("Done! Fetched the data in", stopTime.Sub(startTime))
+	return exitCode
+}
+
+// Creates a DBClient from the database configuration
+func newDBClient(database config.DatabaseConfig) dbclient.DBClient {
+	return dbclient.NewDBClient(dbclient.Config{
+		DBName:   database.Name,
+		Username: database.Username,
+		Password: database.Password,
+		Address:  database.Address,
+	})
 }
 
-// Fetches data from a CloudCostClient for an interval and adding it to the database
-func fetchDataForInterval(db dbclient.DBClient, cloudCost dbclient.CloudCostClient, startDate time.Time, stopDate time.Time) {
-	if startDate.After(stopDate) {
-		log.Fatalf("Fetch for interval: start date can't be after stop date")
+// Fetches data from every provider concurrently and adds it to the database.
+// The results are in the same order as the providers.
+func fetchDataForDate(ctx context.Context, db usageWriter, providers []provider, date time.Time) []fetchResult {
+	log.Println("Getting cost for", date)
+	results := make([]fetchResult, len(providers))
+
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		wg.Add(1)
+		go func(i int, p provider) {
+			defer wg.Done()
+			count, err := fetchProviderDataForDate(ctx, db, p, date)
+			results[i] = fetchResult{provider: p.name, count: count, err: err}
+		}(i, p)
 	}
+	wg.Wait()
+
+	return results
+}
 
-	currentTime := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, startDate.Location())
-	// Make sure stop date will be included
-	stopDate = time.Date(stopDate.Year(), stopDate.Month(), stopDate.Day(), 0, 0, 0, 1, stopDate.Location())
+// Fetches data from one provider and adding it to the database.
+// Returns the number of UsageData that was added. Partial data is only added if the provider allows it,
+// and then the partial error is returned together with the count.
+func fetchProviderDataForDate(ctx context.Context, db usageWriter, p provider, date time.Time) (int, error) {
+	data, err := p.GetCloudCost(ctx, date)
+	if err != nil && !(p.writePartial && isPartial(err) && len(data) > 0) {
+		return 0, err
+	}
 
-	for currentTime.Before(stopDate) {
-		fetchDataForDate(db, cloudCost, currentTime)
-		currentTime = currentTime.AddDate(0, 0, 1)
+	p.labels.Apply(data)
+	if dbErr := db.AddUsageData(data); dbErr != nil {
+		return 0, dbErr
 	}
+
+	return len(data), err
 }
 
-// Fetches data from a CloudCostClient and adding it to the database
-func fetchDataForDate(db dbclient.DBClient, cloudCost dbclient.CloudCostClient, time time.Time) {
-	log.Println("Getting cost for", time)
-	test, err := cloudCost.GetCloudCost(time)
-	if err == nil {
-		if err = db.AddUsageData(test); err != nil {
-			log.Fatalf("DB Error: %v", err.Error())
+// Returns true if the error only concerns a part of the data, e.g. some Azure subscriptions
+func isPartial(err error) bool {
+	_, ok := err.(*azure.PartialError)
+	return ok
+}
+
+// Creates a provider for every configured account of the selected clouds
+func getProviders(cfg config.Config) []provider {
+	var providers []provider
+
+	if cfg.CloudEnabled(config.CloudAWS) {
+		for _, account := range cfg.AWS {
+			log.Println("Initializing AWS client", account.Name+"...")
+			providers = append(providers, provider{
+				name:            account.Name,
+				labels:          cfg.Labels,
+				window:          providerWindow(account.Window, cfg.Schedule),
+				CloudCostClient: initAwsClient(account),
+			})
 		}
+	}
 
-	} else {
-		log.Println("Got error, skipping usage data:", err)
+	if cfg.CloudEnabled(config.CloudAzure) {
+		for _, tenant := range cfg.Azure {
+			log.Println("Initializing Azure client", tenant.Name+"...")
+			azureClient := initAzureClient(tenant)
+			providers = append(providers, provider{
+				name:            tenant.Name,
+				labels:          cfg.Labels,
+				writePartial:    tenant.WritePartial,
+				window:          providerWindow(tenant.Window, cfg.Schedule),
+				CloudCostClient: &azureCloudCost{UsageExplorer: &azureClient},
+			})
+		}
 	}
+
+	return providers
 }
 
-// Retrieves the correct CloudCostClient depending on the cloud flag
-func getCloudCostClient() dbclient.CloudCostClient {
-	var cloudCost dbclient.CloudCostClient
-
-	if strings.EqualFold(*cloud, "azure") {
-		log.Println("Initializing Azure client...")
-		azureClient := initAzureClient()
-		cloudCost = &azureCloudCost{UsageExplorer: &azureClient}
-	} else if strings.EqualFold(*cloud, "aws") {
-		log.Println("Initializing AWS client...")
-		awsClient := initAwsClient()
-		cloudCost = &awsCloudCost{Client: &awsClient}
-	} else {
-		log.Fatalf("Cloud provider \"%v\" is not supported", *cloud)
+// Returns the window of a provider, or the window of the schedule if the provider has none
+func providerWindow(window int, schedule config.ScheduleConfig) int {
+	if window > 0 {
+		return window
 	}
-	return cloudCost
+	return schedule.Window
 }
 
 // Initializes the Azure client
-func initAzureClient() azure.UsageExplorer {
-	explorer := azure.NewUsageExplorer()
+func initAzureClient(tenant config.AzureConfig) azure.UsageExplorer {
+	explorer := azure.NewUsageExplorer(azure.Config{
+		TenantID:      tenant.TenantID,
+		Subscriptions: tenant.Subscriptions,
+		Include:       azure.SubscriptionFilter(tenant.Include),
+		Exclude:       azure.SubscriptionFilter(tenant.Exclude),
+		Timeout:       tenant.Timeout,
+	})
 	return explorer
 }
 
-// Initializes the AWS client
-func initAwsClient() aws.Client {
-	return aws.NewClient("elastisys-billing-data")
+// Initializes the AWS client, reading from local files if a directory is configured
+// or from the Cost Explorer API if that is the source
+func initAwsClient(account config.AWSConfig) dbclient.CloudCostClient {
+	if account.Source == config.AWSSourceCostExplorer {
+		explorerClient := aws.NewExplorerClient(aws.ExplorerConfig{
+			SessionConfig: awsSessionConfig(account),
+			PayerAccount:  account.PayerAccount,
+			GroupBy:       account.GroupBy,
+			CostMetrics:   account.CostMetrics,
+			Hourly:        account.Hourly,
+		})
+		return &awsExplorerCloudCost{ExplorerClient: &explorerClient}
+	}
+
+	ingestion := aws.IngestionConfig{
+		GroupBy:      account.GroupBy,
+		Strict:       account.Strict,
+		MaxRowErrors: account.MaxRowErrors,
+		CostMetrics:  account.CostMetrics,
+		Dimensions:   account.Dimensions,
+		Tags:         account.Tags,
+		Hourly:       account.Hourly,
+		Timezone:     account.Timezone,
+		PayerAccount: account.PayerAccount,
+	}
+
+	if account.Directory != "" {
+		fileClient := aws.NewFileClient(aws.FileConfig{
+			Directory:       account.Directory,
+			IngestionConfig: ingestion,
+		})
+		return &awsFileCloudCost{FileClient: &fileClient}
+	}
+
+	awsClient := aws.NewClient(aws.Config{
+		ReportName:      account.ReportName,
+		Bucket:          account.Bucket,
+		ReportPrefix:    account.ReportPrefix,
+		Region:          account.Region,
+		SessionConfig:   awsSessionConfig(account),
+		IngestionConfig: ingestion,
+	})
+	return &awsCloudCost{Client: &awsClient}
+}
+
+// Returns the credentials and endpoint of an AWS account
+func awsSessionConfig(account config.AWSConfig) aws.SessionConfig {
+	return aws.SessionConfig{
+		Profile:              account.Profile,
+		AccessKeyID:          account.AccessKeyID,
+		SecretAccessKey:      account.SecretAccessKey,
+		SessionToken:         account.SessionToken,
+		SecretsFile:          account.SecretsFile,
+		RoleARN:              account.RoleARN,
+		ExternalID:           account.ExternalID,
+		RoleSessionName:      account.RoleSessionName,
+		WebIdentityTokenFile: account.WebIdentityTokenFile,
+		Endpoint:             account.Endpoint,
+	}
 }
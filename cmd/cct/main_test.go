@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/azure"
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/config"
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+)
+
+// fakeCloudCost returns the same data and error for every day
+type fakeCloudCost struct {
+	data []dbclient.UsageData
+	err  error
+}
+
+func (f fakeCloudCost) GetCloudCost(ctx context.Context, date time.Time) ([]dbclient.UsageData, error) {
+	return f.data, f.err
+}
+
+// fakeWriter keeps the data that was written
+type fakeWriter struct {
+	written []dbclient.UsageData
+	err     error
+}
+
+func (w *fakeWriter) AddUsageData(usageData []dbclient.UsageData) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.written = append(w.written, usageData...)
+	return nil
+}
+
+func TestFetchProviderDataForDate(t *testing.T) {
+	data := []dbclient.UsageData{{Cost: 1, Labels: map[string]string{"cloud": "azure"}}, {Cost: 2, Labels: map[string]string{"cloud": "azure"}}}
+	partial := &azure.PartialError{Subscriptions: 2, Errors: []azure.SubscriptionError{{SubscriptionID: "sub1", Err: errors.New("forbidden")}}}
+	other := errors.New("timeout")
+
+	cases := []struct {
+		name         string
+		client       fakeCloudCost
+		writePartial bool
+		count        int
+		err          error
+	}{
+		{"All data", fakeCloudCost{data: data}, false, 2, nil},
+		{"Partial data not written", fakeCloudCost{data: data, err: partial}, false, 0, partial},
+		{"Partial data written", fakeCloudCost{data: data, err: partial}, true, 2, partial},
+		{"No partial data", fakeCloudCost{err: partial}, true, 0, partial},
+		{"Other error", fakeCloudCost{data: data, err: other}, true, 0, other},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := &fakeWriter{}
+			p := provider{name: "azure", labels: config.LabelRules{Add: map[string]string{"team": "platform"}}, writePartial: c.writePartial, CloudCostClient: c.client}
+
+			count, err := fetchProviderDataForDate(context.Background(), db, p, time.Now())
+			if err != c.err {
+				t.Errorf("Expected error %v but got %v", c.err, err)
+			}
+			if count != c.count || len(db.written) != c.count {
+				t.Errorf("Expected %d usage data to be written but got %d of %d", c.count, len(db.written), count)
+			}
+			for _, d := range db.written {
+				if d.Labels["team"] != "platform" {
+					t.Errorf("Expected the label rules to be applied but got %v", d.Labels)
+				}
+			}
+		})
+	}
+
+	t.Run("Error from the database", func(t *testing.T) {
+		db := &fakeWriter{err: errors.New("unavailable")}
+		p := provider{name: "azure", writePartial: true, CloudCostClient: fakeCloudCost{data: data, err: partial}}
+		if count, err := fetchProviderDataForDate(context.Background(), db, p, time.Now()); count != 0 || err != db.err {
+			t.Errorf("Expected the error of the database but got %d and %v", count, err)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/config"
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+	"github.com/robfig/cron"
+)
+
+// Registers the flags used by the daemon mode
+func addServeFlags(flags *flag.FlagSet) {
+	defaults := config.Default()
+	flags.String("schedule", "", "Cron expression for when to fetch data, e.g. \"0 6 * * *\" or \"@daily\".")
+	flags.Duration("interval", 0, "Time between fetches. Used instead of --schedule.")
+	flags.Int("window", defaults.Schedule.Window, "The number of days, counting today, to fetch every time since costs are revised afterwards.")
+}
+
+// Runs cct as a daemon and returns the exit code
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("cct serve", flag.ExitOnError)
+	addCommonFlags(flags)
+	addServeFlags(flags)
+	flags.Parse(args)
+
+	return serveWithConfig(mustLoadConfig(flags))
+}
+
+// Runs the daemon until it receives a signal
+func serveWithConfig(cfg config.Config) int {
+	schedule, err := getSchedule(cfg.Schedule)
+	if err != nil {
+		log.Println("Serve:", err)
+		return 2
+	}
+
+	db := newDBClient(cfg.Database)
+	providers := getProviders(cfg)
+
+	ctx, cancel := contextWithSignals()
+	defer cancel()
+
+	serve(ctx, db, providers, schedule)
+
+	log.Println("Shut down gracefully")
+	return 0
+}
+
+// Creates the schedule from either the cron expression or the interval
+func getSchedule(schedule config.ScheduleConfig) (cron.Schedule, error) {
+	if schedule.Cron != "" {
+		return cron.ParseStandard(schedule.Cron)
+	} else if schedule.Interval != 0 {
+		return cron.Every(schedule.Interval), nil
+	}
+
+	return nil, errors.New("either a cron schedule or an interval must be given")
+}
+
+// Fetches the trailing window of days of every provider every time the schedule fires.
+// Returns when the context is done. A fetch that is in progress is cancelled.
+func serve(ctx context.Context, db dbclient.DBClient, providers []provider, schedule cron.Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		log.Println("Next fetch at", next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for _, group := range groupByWindow(providers) {
+			startDate, stopDate := trailingWindow(now, group[0].window)
+			summaries, err := fetchDataForInterval(ctx, &db, group, startDate, stopDate)
+			logSummaries(summaries)
+			if err != nil {
+				log.Println("Fetching the window failed:", err)
+			}
+		}
+	}
+}
+
+// Returns the first and the last day of the window of days that ends today, both at the start of the day
+func trailingWindow(now time.Time, window int) (time.Time, time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, 1-window), today
+}
+
+// Groups the providers that have the same window, in the order that the windows first appear
+func groupByWindow(providers []provider) [][]provider {
+	var groups [][]provider
+	index := make(map[int]int)
+	for _, p := range providers {
+		i, ok := index[p.window]
+		if !ok {
+			i = len(groups)
+			index[p.window] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], p)
+	}
+	return groups
+}
+
+// Creates a context that is cancelled on SIGINT or SIGTERM
+func contextWithSignals() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Println("Received", sig, "shutting down...")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return ctx, cancel
+}
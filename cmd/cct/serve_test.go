@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/config"
+)
+
+func TestGetSchedule(t *testing.T) {
+	now := time.Date(2018, time.August, 10, 15, 30, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		schedule config.ScheduleConfig
+		next     time.Time
+		valid    bool
+	}{
+		{"Cron", config.ScheduleConfig{Cron: "0 6 * * *"}, time.Date(2018, time.August, 11, 6, 0, 0, 0, time.UTC), true},
+		{"Descriptor", config.ScheduleConfig{Cron: "@daily"}, time.Date(2018, time.August, 11, 0, 0, 0, 0, time.UTC), true},
+		{"Interval", config.ScheduleConfig{Interval: 6 * time.Hour}, now.Add(6 * time.Hour), true},
+		{"Invalid cron", config.ScheduleConfig{Cron: "every day"}, time.Time{}, false},
+		{"Neither cron nor interval", config.ScheduleConfig{}, time.Time{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			schedule, err := getSchedule(c.schedule)
+			if !c.valid {
+				if err == nil {
+					t.Errorf("Expected error but got none!")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Caught error: %s", err)
+			}
+			if next := schedule.Next(now); !next.Equal(c.next) {
+				t.Errorf("Expected the next run at %v but got %v", c.next, next)
+			}
+		})
+	}
+}
+
+func TestTrailingWindow(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	cases := []struct {
+		name   string
+		now    time.Time
+		window int
+		start  time.Time
+		stop   time.Time
+	}{
+		{"Today", time.Date(2018, time.August, 10, 15, 30, 0, 0, time.UTC), 1, time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC), time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC)},
+		{"Several days", time.Date(2018, time.August, 2, 6, 0, 0, 0, time.UTC), 3, time.Date(2018, time.July, 31, 0, 0, 0, 0, time.UTC), time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC)},
+		// The clocks move forward at 02:00 on the 25th, so the window is one hour shorter but still three days
+		{"Daylight saving time", time.Date(2018, time.March, 26, 0, 30, 0, 0, stockholm), 3, time.Date(2018, time.March, 24, 0, 0, 0, 0, stockholm), time.Date(2018, time.March, 26, 0, 0, 0, 0, stockholm)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			start, stop := trailingWindow(c.now, c.window)
+			if !start.Equal(c.start) || !stop.Equal(c.stop) {
+				t.Errorf("Expected %v to %v but got %v to %v", c.start, c.stop, start, stop)
+			}
+			if days := daysInInterval(start, stop); days != c.window {
+				t.Errorf("Expected %d days but got %d", c.window, days)
+			}
+		})
+	}
+}
+
+func TestGroupByWindow(t *testing.T) {
+	providers := []provider{{name: "aws", window: 3}, {name: "azure", window: 2}, {name: "aws-other", window: 3}}
+
+	groups := groupByWindow(providers)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups but got %v", groups)
+	}
+	if len(groups[0]) != 2 || groups[0][0].name != "aws" || groups[0][1].name != "aws-other" {
+		t.Errorf("Expected aws and aws-other in the first group but got %v", groups[0])
+	}
+	if len(groups[1]) != 1 || groups[1][0].name != "azure" {
+		t.Errorf("Expected azure in the second group but got %v", groups[1])
+	}
+}
+
+func TestProviderWindow(t *testing.T) {
+	schedule := config.ScheduleConfig{Window: 3}
+	if window := providerWindow(0, schedule); window != 3 {
+		t.Errorf("Expected the window of the schedule but got %d", window)
+	}
+	if window := providerWindow(5, schedule); window != 5 {
+		t.Errorf("Expected the window of the provider but got %d", window)
+	}
+}
@@ -4,3 +4,7 @@ echo "Downloading Azure SDK..."
 go get -u github.com/Azure/azure-sdk-for-go/...
 echo "Downloading InfluxDB Client..."
 go get -u github.com/influxdata/influxdb/client/v2
+echo "Downloading cron..."
+go get -u github.com/robfig/cron
+echo "Downloading YAML..."
+go get -u gopkg.in/yaml.v2
@@ -2,88 +2,226 @@
 package aws
 
 import (
+	"context"
 	"encoding/csv"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	cur "github.com/aws/aws-sdk-go/service/costandusagereportservice"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
 	"io"
+	"log"
 	"math"
-	"strconv"
+	"path"
+	"sort"
 	"strings"
 	"time"
 )
 
+// Config describes where the Cost and Usage Report is stored and how to access it
+type Config struct {
+	// ReportName is the name of the Cost and Usage Report
+	ReportName string
+	// Bucket, ReportPrefix and Region are read from the report definition if Bucket is empty
+	Bucket       string
+	ReportPrefix string
+	// Region is taken from the shared config if empty
+	Region string
+	SessionConfig
+	IngestionConfig
+}
+
+// IngestionConfig describes how the rows of the report are turned into UsageData
+type IngestionConfig struct {
+	// GroupBy lists the labels that line items are grouped by. Empty means all labels.
+	GroupBy []string
+	// Strict fails the day if more than MaxRowErrors rows of the report can't be read.
+	// Otherwise such rows are skipped with a warning.
+	Strict       bool
+	MaxRowErrors int
+	// CostMetrics lists the metrics to read, e.g. MetricAmortized. The first one is written as the cost
+	// and all of them as fields if there are more than one. Empty means blended cost.
+	CostMetrics []string
+	// Dimensions, e.g. DimensionAccount, and cost allocation tags, e.g. team, to add as labels
+	Dimensions []string
+	Tags       []string
+	// Hourly writes the cost of every hour of the day instead of one value for the day
+	Hourly bool
+	// Timezone is the IANA name of the time zone that days are counted in, e.g. Europe/Stockholm. Empty means UTC.
+	Timezone string
+	// PayerAccount is written as the payer_account label. Empty means the payer account ID of the report.
+	PayerAccount string
+}
+
+// s3API is the part of the S3 service that is used, to simplify testing
+type s3API interface {
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error
+	SelectObjectContentWithContext(ctx aws.Context, input *s3.SelectObjectContentInput, opts ...request.Option) (*s3.SelectObjectContentOutput, error)
+}
+
 // Client represents a connection to an aws S3 bucket
 type Client struct {
-	bucket  string
-	service *s3.S3
+	bucket       string
+	reportPrefix string
+	reportName   string
+	// service is nil until the location of the report is read from the report definition
+	service s3API
+	// locate reads where the report is stored, it is only set if the bucket isn't configured
+	locate func(ctx context.Context) (Config, error)
+	// newService creates the service of the bucket once the location of the report is known
+	newService func(config Config) s3API
+	ingestion
 }
 
-// NewClient initializes a new S3 connection
-func NewClient(bucket string) Client {
-	svc := newS3Service()
-	client := Client{bucket: bucket, service: svc}
+// NewClient initializes a new S3 connection.
+// Without a bucket the report definition is read on the first call of GetCloudCost, instead of here,
+// so that a failing request doesn't stop the other providers.
+func NewClient(config Config) Client {
+	sess, err := newSession(config.SessionConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := Client{
+		bucket:       config.Bucket,
+		reportPrefix: config.ReportPrefix,
+		reportName:   config.ReportName,
+		ingestion:    newIngestion(config.IngestionConfig),
+		newService: func(config Config) s3API {
+			return newS3Service(sess, config)
+		},
+	}
+	if config.Bucket == "" {
+		api := cur.New(sess, aws.NewConfig().WithRegion(reportServiceRegion))
+		client.locate = func(ctx context.Context) (Config, error) {
+			definition, err := getReportDefinition(ctx, api, config.ReportName)
+			if err != nil {
+				return config, err
+			}
+			return configFromDefinition(config, definition), nil
+		}
+	} else {
+		client.service = newS3Service(sess, config)
+	}
 	return client
 }
 
-func newS3Service() *s3.S3 {
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	return s3.New(sess)
+// locateReport reads where the report is stored from the report definition, unless it is already known.
+// The location is kept, so the definition is only read again if it failed.
+func (client *Client) locateReport(ctx context.Context) error {
+	if client.service != nil {
+		return nil
+	}
+	config, err := client.locate(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to read the definition of the report %s: %v", client.reportName, err)
+	}
+	client.bucket = config.Bucket
+	client.reportPrefix = config.ReportPrefix
+	client.service = client.newService(config)
+	return nil
+}
+
+func newS3Service(sess *session.Session, config Config) *s3.S3 {
+	return s3.New(sess, serviceConfig(config.Region, config.SessionConfig))
 }
 
-func (client *Client) getTable(key string, query string) ([][]string, error) {
+// selectInput returns the S3 Select request for the query on a part of the report in the given format.
+// The records are always returned as CSV.
+func (client *Client) selectInput(key string, query *reportQuery, format reportFormat) *s3.SelectObjectContentInput {
+	input := &s3.InputSerialization{}
+	if format == formatParquet {
+		input.Parquet = &s3.ParquetInput{}
+	} else {
+		input.CSV = &s3.CSVInput{
+			FileHeaderInfo: aws.String(s3.FileHeaderInfoIgnore),
+		}
+		input.CompressionType = aws.String(s3.CompressionTypeNone)
+		if strings.HasSuffix(key, ".gz") {
+			input.CompressionType = aws.String(s3.CompressionTypeGzip)
+		}
+	}
 
-	// Select contents of target csv
-	params := &s3.SelectObjectContentInput{
-		Bucket:         aws.String(client.bucket),
-		Key:            aws.String(key),
-		ExpressionType: aws.String(s3.ExpressionTypeSql),
-		Expression:     aws.String(query),
-		InputSerialization: &s3.InputSerialization{
-			CompressionType: aws.String("gzip"),
-			CSV: &s3.CSVInput{
-				FileHeaderInfo: aws.String(s3.FileHeaderInfoIgnore),
-			},
-		},
+	return &s3.SelectObjectContentInput{
+		Bucket:             aws.String(client.bucket),
+		Key:                aws.String(key),
+		ExpressionType:     aws.String(s3.ExpressionTypeSql),
+		Expression:         aws.String(query.sql(format)),
+		InputSerialization: input,
 		OutputSerialization: &s3.OutputSerialization{
 			CSV: &s3.CSVOutput{},
 		},
 	}
+}
+
+// getTable selects the query from a part of the report. Malformed CSV rows are skipped and returned as errors,
+// while errors from the request or the event stream fail the whole part.
+func (client *Client) getTable(ctx context.Context, key string, query *reportQuery, format reportFormat) ([]reportRecord, []RowError, error) {
+	params := client.selectInput(key, query, format)
 
 	// Request stream
-	resp, err := client.service.SelectObjectContent(params)
+	resp, err := client.service.SelectObjectContentWithContext(ctx, params)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("unable to select from s3://%s/%s: %v", client.bucket, key, err)
 	}
 	defer resp.EventStream.Close()
 
 	// Get data from stream
 	results, resultWriter := io.Pipe()
+	defer results.Close()
 	go func() {
-		defer resultWriter.Close()
 		for event := range resp.EventStream.Events() {
 			switch e := event.(type) {
 			case *s3.RecordsEvent:
-				resultWriter.Write(e.Payload)
+				if _, err := resultWriter.Write(e.Payload); err != nil {
+					// The reader is closed, stop reading the stream
+					return
+				}
 			}
 		}
+		resultWriter.CloseWithError(resp.EventStream.Err())
 	}()
 
-	// Read the csv into a slice
-	tbl := make([][]string, 0)
-	resReader := csv.NewReader(results)
+	// The header of a CSV report is left out of the results, so the lines are one less than in the report
+	header := 1
+	if format == formatParquet {
+		header = 0
+	}
+
+	tbl, errs, err := readRecords(results, key, len(query.reportPositions), header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read s3://%s/%s: %v", client.bucket, key, err)
+	}
+	return tbl, errs, nil
+}
+
+// readRecords reads CSV records with the number of fields until the end of the reader.
+// The rows are counted from the line after the header lines. Malformed rows are skipped and returned as errors,
+// any other error from the reader stops the reading.
+func readRecords(r io.Reader, key string, fields int, header int) ([]reportRecord, []RowError, error) {
+	tbl := make([]reportRecord, 0)
+	var errs []RowError
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = fields
 	for {
-		record, err := resReader.Read()
+		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
-		tbl = append(tbl, record)
+		if perr, ok := err.(*csv.ParseError); ok {
+			errs = append(errs, RowError{Key: key, Row: perr.StartLine + header, Err: perr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		tbl = append(tbl, reportRecord{row: line + header, values: record})
 	}
-
-	return tbl, nil
+	return tbl, errs, nil
 }
 
 func overlap(a int64, b int64, c int64, d int64) float64 {
@@ -99,91 +237,112 @@ func calculateRatio(start time.Time, stop time.Time, date time.Time) float64 {
 }
 
 // GetCloudCost returns information about the cost during a specific day
-func (client *Client) GetCloudCost(timestamp time.Time) ([]dbclient.UsageData, error) {
-	// S3 Select
-	// 0  1         2        3       4        5
-	// id StartDate StopDate Service Currency BlendedCost
-	query := "SELECT s._1, s._11, s._12, s._13, s._20, s._24 FROM S3Object s"
+// The day is taken in the billing time zone and read from the report of every billing period that it overlaps.
+func (client *Client) GetCloudCost(ctx context.Context, timestamp time.Time) ([]dbclient.UsageData, error) {
+	if err := client.locateReport(ctx); err != nil {
+		return nil, err
+	}
+	day := client.day(timestamp)
+	report := reportRows{ingestion: &client.ingestion}
 
-	form := "2006-01-02T15:04:05Z"
+	for _, period := range billingPeriodsOfDay(day) {
+		// The manifest lists all parts and columns of the report
+		manifest, err := client.getManifest(ctx, period)
+		if err != nil {
+			return nil, err
+		}
 
-	// Calculate the correct key
-	key := client.getBucketKey(timestamp)
+		query, err := client.newQuery(manifest.Columns)
+		if err != nil {
+			return nil, err
+		}
 
-	// Get table from bucket with key using query
-	tbl, err := client.getTable(key, query)
-	if err != nil {
-		return nil, err
+		// Get table from every part of the report using query and transform it into internal format []UsageData
+		for _, key := range manifest.ReportKeys {
+			tbl, errs, err := client.getTable(ctx, key, query, manifest.format(key))
+			if err != nil {
+				return nil, err
+			}
+			report.add(key, query, tbl, errs, day)
+		}
 	}
 
-	// Transform result into internal format []UsageData
+	return client.finish(report.data, report.rows, report.errs)
+}
+
+// Labels that are always kept when grouping since the cost can't be summed across them,
+// e.g. usage would be hidden by credits if the line item types were summed.
+// The payer account is kept to compare organizations side by side.
+var alwaysGroupedBy = []string{"cloud", "currency", "line_item_type", "payer_account"}
+
+// groupUsageData sums the cost and fields of UsageData with the same date and grouping labels.
+// Labels that are not grouped by are dropped and an empty groupBy means all labels.
+// Attributes are dropped unless they are the same for all UsageData in the group.
+// The groups are returned in the order they first appear.
+func groupUsageData(data []dbclient.UsageData, groupBy []string) []dbclient.UsageData {
 	res := make([]dbclient.UsageData, 0)
-	for _, val := range tbl {
-		labels := map[string]string{}
-		labels["id"] = val[0]
-		labels["service"] = val[3]
-		labels["currency"] = val[4]
-		labels["cloud"] = "aws"
-		start, _ := time.Parse(form, val[1])
-		stop, _ := time.Parse(form, val[2])
-		ratio := calculateRatio(start, stop, timestamp)
-		cost, _ := strconv.ParseFloat(val[5], 64)
-		row := dbclient.UsageData{
-			Cost:   cost * ratio,
-			Date:   timestamp,
-			Labels: labels}
-		res = append(res, row)
-	}
-
-	// Group similar UsageData
-	res = groupUsageData(res)
-
-	return res, nil
-}
-
-// TODO
-func groupUsageData(data []dbclient.UsageData) []dbclient.UsageData {
-	return data
-}
-
-func keyIsValid(key string, date string) bool {
-	if !strings.Contains(key, date) {
-		return false
-	} else if !strings.Contains(key, "csv.gz") {
-		return false
-	}
-	return true
-}
-
-func selectKey(objects []*s3.Object, date string) string {
-	var latest time.Time
-	var key string
-	latest = *objects[0].LastModified
-	key = *objects[0].Key
-	for _, val := range objects {
-		if keyIsValid(*val.Key, date) {
-			if val.LastModified.After(latest) {
-				latest = *val.LastModified
-				key = *val.Key
+	groups := make(map[string]int)
+
+	var keys []string
+	if len(groupBy) > 0 {
+		keys = append(append(keys, groupBy...), alwaysGroupedBy...)
+	}
+
+	for _, row := range data {
+		labels := row.Labels
+		if len(keys) > 0 {
+			labels = make(map[string]string)
+			for _, key := range keys {
+				if value, ok := row.Labels[key]; ok {
+					labels[key] = value
+				}
+			}
+		}
+
+		key := groupKey(row.Date, labels)
+		i, ok := groups[key]
+		if !ok {
+			groups[key] = len(res)
+			res = append(res, dbclient.UsageData{Date: row.Date, Labels: labels, Fields: make(map[string]float64), Attributes: make(map[string]string)})
+			i = len(res) - 1
+			for attribute, value := range row.Attributes {
+				res[i].Attributes[attribute] = value
+			}
+		} else {
+			// Only attributes that are the same for the whole group are kept
+			for attribute, value := range res[i].Attributes {
+				if row.Attributes[attribute] != value {
+					delete(res[i].Attributes, attribute)
+				}
 			}
 		}
+
+		res[i].Cost += row.Cost
+		for field, value := range row.Fields {
+			res[i].Fields[field] += value
+		}
 	}
-	return key
+
+	return res
 }
 
-func (client *Client) getBucketKey(timestamp time.Time) string {
-	params := &s3.ListObjectsInput{
-		Bucket: aws.String(client.bucket),
-		Prefix: aws.String("daily-report/test-usage-report"),
+// groupKey returns a string that is unique for the date and labels
+func groupKey(date time.Time, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	form := "20060102"
-	start := time.Date(timestamp.Year(), timestamp.Month(), 1, 0, 0, 0, 0, timestamp.Location())
-	stop := start.AddDate(0, 1, 0)
-	date := start.Format(form) + "-" + stop.Format(form)
-
-	resp, _ := client.service.ListObjects(params)
-	key := selectKey(resp.Contents, date)
+	var b strings.Builder
+	b.WriteString(date.UTC().Format(time.RFC3339Nano))
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\x00%s=%s", key, labels[key])
+	}
+	return b.String()
+}
 
-	return key
+// reportPath returns the path that all files of the report are stored under
+func reportPath(prefix, name string) string {
+	return path.Join(prefix, name) + "/"
 }
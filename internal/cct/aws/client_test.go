@@ -1,11 +1,21 @@
 package aws
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
 )
 
 func init() {
@@ -13,6 +23,55 @@ func init() {
 	log.SetOutput(ioutil.Discard)
 }
 
+// fakeS3 serves objects from memory and lists them in pages
+type fakeS3 struct {
+	objects  map[string]string
+	modified map[string]time.Time
+	pageSize int
+	listErr  error
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	content, ok := f.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(content))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	if f.listErr != nil {
+		return f.listErr
+	}
+
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for start := 0; start < len(keys) || start == 0; start += f.pageSize {
+		stop := start + f.pageSize
+		if stop > len(keys) {
+			stop = len(keys)
+		}
+		page := &s3.ListObjectsV2Output{}
+		for _, key := range keys[start:stop] {
+			page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key), LastModified: aws.Time(f.modified[key])})
+		}
+		if !fn(page, stop == len(keys)) || stop == len(keys) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeS3) SelectObjectContentWithContext(ctx aws.Context, input *s3.SelectObjectContentInput, opts ...request.Option) (*s3.SelectObjectContentOutput, error) {
+	return nil, errors.New("S3 Select is not supported by the fake")
+}
+
 func TestCalculateRatio(t *testing.T) {
 	start := time.Date(2018, time.June, 10, 0, 0, 0, 0, time.UTC)
 	stop := time.Date(2018, time.June, 20, 0, 0, 0, 0, time.UTC)
@@ -46,6 +105,42 @@ func TestCalculateRatioLong(t *testing.T) {
 	}
 }
 
+func TestCalculateRatioBoundaries(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	utc := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2018, month, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name        string
+		start, stop time.Time
+		date        time.Time
+		want        float64
+	}{
+		{"Last day of the month", utc(time.July, 31, 0), utc(time.August, 1, 0), utc(time.July, 31, 0), 1},
+		{"First day of the month", utc(time.July, 31, 0), utc(time.August, 1, 0), utc(time.August, 1, 0), 0},
+		{"Over the new year", time.Date(2017, time.December, 31, 12, 0, 0, 0, time.UTC), utc(time.January, 1, 12), utc(time.January, 1, 0), 0.5},
+		{"Last day of the year", time.Date(2017, time.December, 31, 12, 0, 0, 0, time.UTC), utc(time.January, 1, 12), time.Date(2017, time.December, 31, 0, 0, 0, 0, time.UTC), 0.5},
+		// 2018-08-01 in Stockholm starts at 2018-07-31T22:00:00Z
+		{"Previous month in UTC", utc(time.July, 31, 22), utc(time.July, 31, 23), time.Date(2018, time.August, 1, 0, 0, 0, 0, stockholm), 1},
+		{"Day before in UTC", utc(time.July, 31, 0), utc(time.August, 1, 0), time.Date(2018, time.August, 1, 0, 0, 0, 0, stockholm), 2.0 / 24},
+		// 2018-03-25 in Stockholm has 23 hours and 2018-10-28 has 25 hours
+		{"Spring DST change", utc(time.March, 24, 23), utc(time.March, 25, 22), time.Date(2018, time.March, 25, 0, 0, 0, 0, stockholm), 1},
+		{"Hour after spring DST change", utc(time.March, 25, 22), utc(time.March, 25, 23), time.Date(2018, time.March, 25, 0, 0, 0, 0, stockholm), 0},
+		{"Autumn DST change", utc(time.October, 27, 22), utc(time.October, 28, 23), time.Date(2018, time.October, 28, 0, 0, 0, 0, stockholm), 1},
+		{"Hour before autumn DST change", utc(time.October, 27, 21), utc(time.October, 27, 22), time.Date(2018, time.October, 28, 0, 0, 0, 0, stockholm), 0},
+	}
+
+	for _, c := range cases {
+		if actual := calculateRatio(c.start, c.stop, c.date); math.Abs(actual-c.want) > 1e-9 {
+			t.Errorf("%s: expected ratio %f but got %f", c.name, c.want, actual)
+		}
+	}
+}
+
 func TestOverlapSimple(t *testing.T) {
 	expected := 1.0
 	actual := overlap(0, 1, 0, 2)
@@ -69,3 +164,166 @@ func TestOverlapContained(t *testing.T) {
 		t.Errorf("Expected value %f and actual value %f are not the same!", expected, actual)
 	}
 }
+
+func TestReportPath(t *testing.T) {
+	cases := []struct {
+		prefix, name, want string
+	}{
+		{"daily-report", "test-usage-report", "daily-report/test-usage-report/"},
+		{"daily-report/", "test-usage-report", "daily-report/test-usage-report/"},
+		{"", "test-usage-report", "test-usage-report/"},
+	}
+
+	for _, c := range cases {
+		if actual := reportPath(c.prefix, c.name); actual != c.want {
+			t.Errorf("Expected path %s but got %s", c.want, actual)
+		}
+	}
+}
+
+func TestSelectInput(t *testing.T) {
+	client := Client{bucket: "billing"}
+	query, err := newReportQuery(testColumns, blendedColumns, nil)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	t.Run("Parquet", func(t *testing.T) {
+		input := client.selectInput("report-1.snappy.parquet", query, formatParquet)
+		if input.InputSerialization.Parquet == nil || input.InputSerialization.CSV != nil {
+			t.Errorf("Expected Parquet input but got %v", input.InputSerialization)
+		}
+		if !strings.Contains(aws.StringValue(input.Expression), `s."line_item_blended_cost"`) {
+			t.Errorf("Expected columns selected by name but got %s", aws.StringValue(input.Expression))
+		}
+	})
+
+	t.Run("Compressed CSV", func(t *testing.T) {
+		input := client.selectInput("report-1.csv.gz", query, formatCSV)
+		if input.InputSerialization.CSV == nil || aws.StringValue(input.InputSerialization.CompressionType) != s3.CompressionTypeGzip {
+			t.Errorf("Expected gzip CSV input but got %v", input.InputSerialization)
+		}
+	})
+
+	t.Run("Uncompressed CSV", func(t *testing.T) {
+		input := client.selectInput("report-1.csv", query, formatCSV)
+		if aws.StringValue(input.InputSerialization.CompressionType) != s3.CompressionTypeNone {
+			t.Errorf("Expected uncompressed CSV input but got %v", input.InputSerialization)
+		}
+	})
+}
+
+func TestReadRecords(t *testing.T) {
+	rows := "a,1\nb,2,extra\nc,3\n"
+
+	t.Run("End of stream", func(t *testing.T) {
+		tbl, errs, err := readRecords(strings.NewReader(rows), "report-1.csv.gz", 2, 1)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		// The second row has too many fields, the rows are lines of the report after the header
+		if len(tbl) != 2 || tbl[0].row != 2 || tbl[1].row != 4 || tbl[1].values[0] != "c" {
+			t.Errorf("Expected rows 2 and 4 but got %v", tbl)
+		}
+		if len(errs) != 1 || errs[0].Row != 3 || errs[0].Key != "report-1.csv.gz" {
+			t.Errorf("Expected row 3 to fail but got %v", errs)
+		}
+	})
+
+	t.Run("Empty stream", func(t *testing.T) {
+		tbl, errs, err := readRecords(strings.NewReader(""), "report-1.csv.gz", 2, 1)
+		if err != nil || len(tbl) != 0 || len(errs) != 0 {
+			t.Errorf("Expected no rows but got %v, %v and %v", tbl, errs, err)
+		}
+	})
+
+	t.Run("Error in stream", func(t *testing.T) {
+		// Like the event stream, the pipe fails after some of the rows are written
+		results, resultWriter := io.Pipe()
+		streamErr := errors.New("connection reset")
+		go func() {
+			resultWriter.Write([]byte(rows))
+			resultWriter.CloseWithError(streamErr)
+		}()
+
+		tbl, errs, err := readRecords(results, "report-1.csv.gz", 2, 1)
+		if err != streamErr {
+			t.Errorf("Expected %v but got %v", streamErr, err)
+		}
+		if tbl != nil || errs != nil {
+			t.Errorf("Expected no rows of a failed stream but got %v and %v", tbl, errs)
+		}
+	})
+}
+
+func TestGroupUsageData(t *testing.T) {
+	date := time.Date(2018, time.June, 10, 0, 0, 0, 0, time.UTC)
+	newData := func(cost float64, service, account string) dbclient.UsageData {
+		return dbclient.UsageData{
+			Cost:   cost,
+			Date:   date,
+			Labels: map[string]string{"cloud": "aws", "currency": "USD", "service": service, "account": account},
+			Fields: map[string]float64{"usage_quantity": 2 * cost, "line_items": 1},
+		}
+	}
+	data := []dbclient.UsageData{
+		newData(1, "AmazonS3", "a"),
+		newData(2, "AmazonEC2", "a"),
+		newData(3, "AmazonS3", "b"),
+		newData(4, "AmazonS3", "a"),
+	}
+
+	t.Run("Identical labels", func(t *testing.T) {
+		actual := groupUsageData(data, nil)
+		expected := []float64{5, 2, 3}
+		checkGroups(t, expected, actual)
+		if actual[0].Fields["usage_quantity"] != 10 || actual[0].Fields["line_items"] != 2 {
+			t.Errorf("Fields not summed: %v", actual[0].Fields)
+		}
+	})
+
+	t.Run("Group by service", func(t *testing.T) {
+		actual := groupUsageData(data, []string{"service"})
+		expected := []float64{8, 2}
+		checkGroups(t, expected, actual)
+		if _, ok := actual[0].Labels["account"]; ok {
+			t.Errorf("Expected account label to be dropped: %v", actual[0].Labels)
+		}
+		if actual[0].Labels["currency"] != "USD" || actual[0].Labels["cloud"] != "aws" {
+			t.Errorf("Expected currency and cloud labels to be kept: %v", actual[0].Labels)
+		}
+		if actual[0].Fields["line_items"] != 3 {
+			t.Errorf("Expected 3 line items, got %f", actual[0].Fields["line_items"])
+		}
+	})
+
+	t.Run("Attributes", func(t *testing.T) {
+		first, second := newData(1, "AmazonS3", "a"), newData(2, "AmazonS3", "a")
+		first.Attributes = map[string]string{"line_item_id": "1", "region": "eu-west-1"}
+		second.Attributes = map[string]string{"line_item_id": "2", "region": "eu-west-1"}
+		actual := groupUsageData([]dbclient.UsageData{first, second}, nil)
+		checkGroups(t, []float64{3}, actual)
+		if len(actual[0].Attributes) != 1 || actual[0].Attributes["region"] != "eu-west-1" {
+			t.Errorf("Expected only the common attribute region to be kept: %v", actual[0].Attributes)
+		}
+	})
+
+	t.Run("Different currencies", func(t *testing.T) {
+		other := newData(5, "AmazonS3", "a")
+		other.Labels["currency"] = "SEK"
+		actual := groupUsageData(append(data, other), []string{"service"})
+		expected := []float64{8, 2, 5}
+		checkGroups(t, expected, actual)
+	})
+}
+
+func checkGroups(t *testing.T, expected []float64, actual []dbclient.UsageData) {
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %d groups, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if math.Abs(actual[i].Cost-expected[i]) > 1e-9 {
+			t.Errorf("Expected cost %f for group %d, got %f", expected[i], i, actual[i].Cost)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Columns of the report, named as category/name like in the header of the report
+const (
+	columnLineItemID       = "identity/LineItemId"
+	columnPayerAccountID   = "bill/PayerAccountId"
+	columnUsageStart       = "lineItem/UsageStartDate"
+	columnUsageEnd         = "lineItem/UsageEndDate"
+	columnProductCode      = "lineItem/ProductCode"
+	columnCurrency         = "lineItem/CurrencyCode"
+	columnUsageAmount      = "lineItem/UsageAmount"
+	columnLineItemType     = "lineItem/LineItemType"
+	columnBlendedCost      = "lineItem/BlendedCost"
+	columnUnblendedCost    = "lineItem/UnblendedCost"
+	columnNetUnblendedCost = "lineItem/NetUnblendedCost"
+	columnPublicOnDemand   = "pricing/publicOnDemandCost"
+	columnUsageAccountID   = "lineItem/UsageAccountId"
+	columnRegion           = "product/region"
+	columnUsageType        = "lineItem/UsageType"
+	columnResourceID       = "lineItem/ResourceId"
+	columnTagPrefix        = "resourceTags/user:"
+
+	columnReservationARN             = "reservation/ReservationARN"
+	columnReservationEffectiveCost   = "reservation/EffectiveCost"
+	columnReservationUnusedUpfront   = "reservation/UnusedAmortizedUpfrontFeeForBillingPeriod"
+	columnReservationUnusedRecurring = "reservation/UnusedRecurringFee"
+	columnSavingsPlanEffectiveCost   = "savingsPlan/SavingsPlanEffectiveCost"
+	columnSavingsPlanTotalCommitment = "savingsPlan/TotalCommitmentToDate"
+	columnSavingsPlanUsedCommitment  = "savingsPlan/UsedCommitment"
+)
+
+// The columns that every report must have, in addition to the columns of the cost metrics
+var requiredColumns = []string{
+	columnLineItemID,
+	columnUsageStart,
+	columnUsageEnd,
+	columnProductCode,
+	columnCurrency,
+}
+
+// The columns that are read if the report has them
+var optionalColumns = []string{
+	columnUsageAmount,
+	columnLineItemType,
+	columnPayerAccountID,
+}
+
+// reportQuery selects columns from the report by name
+type reportQuery struct {
+	// Position of each column in the report, starting at 1 like in S3 Select
+	reportPositions []int
+	// Name of each selected column
+	names []string
+	// Position of each selected column in the returned records
+	positions map[string]int
+}
+
+// columnName returns the name of a manifest column as it is written in the header of the report.
+// Columns of CUR 2.0 have no category and are named like line_item_usage_start_date.
+func columnName(column manifestColumn) string {
+	if column.Category == "" {
+		return column.Name
+	}
+	return column.Category + "/" + column.Name
+}
+
+// newReportQuery creates a query for the required and optional columns.
+// The columns are named as category/name, and are also found in reports that name them like Parquet reports,
+// e.g. line_item_usage_start_date for lineItem/UsageStartDate. Optional columns that are not in the report
+// are not selected. Returns an error naming every required column that is missing.
+// Without any columns, e.g. for a report without a manifest, all columns are selected by name.
+func newReportQuery(columns []manifestColumn, required []string, optional []string) (*reportQuery, error) {
+	reportPositions := make(map[string]int)
+	parquetPositions := make(map[string]int)
+	for i, column := range columns {
+		name := columnName(column)
+		// Keep the first column if a name is repeated
+		if _, ok := reportPositions[name]; !ok {
+			reportPositions[name] = i + 1
+		}
+		if _, ok := parquetPositions[parquetColumnName(name)]; !ok {
+			parquetPositions[parquetColumnName(name)] = i + 1
+		}
+	}
+
+	query := &reportQuery{positions: make(map[string]int)}
+	lookup := func(name string) (int, bool) {
+		if len(columns) == 0 {
+			return len(query.reportPositions) + 1, true
+		}
+		if position, ok := reportPositions[name]; ok {
+			return position, true
+		}
+		position, ok := parquetPositions[parquetColumnName(name)]
+		return position, ok
+	}
+	add := func(name string) bool {
+		position, ok := lookup(name)
+		if !ok {
+			return false
+		}
+		if _, ok := query.positions[name]; !ok {
+			query.positions[name] = len(query.reportPositions)
+			query.reportPositions = append(query.reportPositions, position)
+			query.names = append(query.names, name)
+		}
+		return true
+	}
+
+	var missing []string
+	for _, name := range required {
+		if !add(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the report is missing the required columns %s", strings.Join(missing, ", "))
+	}
+	for _, name := range optional {
+		add(name)
+	}
+
+	return query, nil
+}
+
+// sql returns the S3 Select expression for the query.
+// CSV columns are selected by position since the header is ignored, while Parquet columns are selected by name.
+func (query *reportQuery) sql(format reportFormat) string {
+	selected := make([]string, len(query.reportPositions))
+	for i, position := range query.reportPositions {
+		if format == formatParquet {
+			selected[i] = fmt.Sprintf("s.\"%s\"", parquetColumnName(query.names[i]))
+		} else {
+			selected[i] = fmt.Sprintf("s._%d", position)
+		}
+	}
+	return "SELECT " + strings.Join(selected, ", ") + " FROM S3Object s"
+}
+
+// parquetColumnName returns the name of a column in a Parquet report, e.g. line_item_usage_start_date for
+// lineItem/UsageStartDate and resource_tags_user_team for resourceTags/user:team
+func parquetColumnName(name string) string {
+	var b strings.Builder
+	var previous rune
+	for _, r := range name {
+		switch {
+		case unicode.IsUpper(r):
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			// Separators like / and : become a single underscore
+			if previous != 0 && previous != '_' {
+				b.WriteRune('_')
+			}
+			r = '_'
+		}
+		previous = r
+	}
+	return b.String()
+}
+
+// has returns true if the column is selected
+func (query *reportQuery) has(name string) bool {
+	_, ok := query.positions[name]
+	return ok
+}
+
+// value returns the value of a column in a record returned by the query.
+// Columns that are not selected are empty.
+func (query *reportQuery) value(record []string, name string) string {
+	position, ok := query.positions[name]
+	if !ok || position >= len(record) {
+		return ""
+	}
+	return record[position]
+}
+
+// selectColumns returns the selected columns of a full record of the report,
+// like the records returned by S3 Select for the query
+func (query *reportQuery) selectColumns(record []string) []string {
+	selected := make([]string, len(query.reportPositions))
+	for i, position := range query.reportPositions {
+		if position <= len(record) {
+			selected[i] = record[position-1]
+		}
+	}
+	return selected
+}
@@ -0,0 +1,124 @@
+package aws
+
+import (
+	"testing"
+)
+
+var testColumns = []manifestColumn{
+	{Category: "identity", Name: "LineItemId"},
+	{Category: "identity", Name: "TimeInterval"},
+	{Category: "lineItem", Name: "UsageStartDate"},
+	{Category: "lineItem", Name: "UsageEndDate"},
+	{Category: "lineItem", Name: "ProductCode"},
+	{Category: "lineItem", Name: "CurrencyCode"},
+	{Category: "resourceTags", Name: "user:team"},
+	{Category: "lineItem", Name: "BlendedCost"},
+}
+
+// The columns that are required by the default cost metric
+var blendedColumns = []string{columnLineItemID, columnUsageStart, columnUsageEnd, columnProductCode, columnCurrency, columnBlendedCost}
+
+func TestNewReportQuery(t *testing.T) {
+	query, err := newReportQuery(testColumns, blendedColumns, []string{"resourceTags/user:team", "resourceTags/user:project"})
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	expected := "SELECT s._1, s._3, s._4, s._5, s._6, s._8, s._7 FROM S3Object s"
+	if actual := query.sql(formatCSV); actual != expected {
+		t.Errorf("Expected query %s but got %s", expected, actual)
+	}
+
+	if !query.has("resourceTags/user:team") || query.has("resourceTags/user:project") {
+		t.Errorf("Optional columns not selected correctly")
+	}
+
+	record := []string{"id", "2018-08-01T00:00:00Z", "2018-08-01T01:00:00Z", "AmazonS3", "USD", "0.5", "platform"}
+	cases := map[string]string{
+		columnLineItemID:            "id",
+		columnProductCode:           "AmazonS3",
+		columnBlendedCost:           "0.5",
+		"resourceTags/user:team":    "platform",
+		"resourceTags/user:project": "",
+	}
+	for name, want := range cases {
+		if actual := query.value(record, name); actual != want {
+			t.Errorf("Expected %s=%s, actual: %s=%s", name, want, name, actual)
+		}
+	}
+}
+
+func TestNewReportQueryMissingColumn(t *testing.T) {
+	// Remove the cost column
+	columns := testColumns[:len(testColumns)-1]
+
+	_, err := newReportQuery(columns, blendedColumns, nil)
+	if err == nil {
+		t.Errorf("Expected error but got none!")
+	}
+}
+
+func TestNewReportQueryUncategorised(t *testing.T) {
+	// Columns of CUR 2.0 are named like the columns of Parquet reports
+	columns := []manifestColumn{{Name: "identity_line_item_id"}, {Name: "line_item_usage_start_date"}, {Name: "product_region"}}
+	query, err := newReportQuery(columns, []string{columnLineItemID, columnUsageStart}, []string{columnRegion, columnUsageType})
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	expected := "SELECT s._1, s._2, s._3 FROM S3Object s"
+	if actual := query.sql(formatCSV); actual != expected {
+		t.Errorf("Expected query %s but got %s", expected, actual)
+	}
+	if !query.has(columnRegion) || query.has(columnUsageType) {
+		t.Errorf("Optional columns not selected correctly")
+	}
+
+	if _, err := newReportQuery(columns, blendedColumns, nil); err == nil {
+		t.Errorf("Expected error but got none!")
+	}
+}
+
+func TestNewReportQueryWithoutColumns(t *testing.T) {
+	// Without a manifest every column is selected by name
+	query, err := newReportQuery(nil, []string{columnLineItemID, columnUsageStart}, []string{"resourceTags/user:team"})
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	expected := `SELECT s."identity_line_item_id", s."line_item_usage_start_date", s."resource_tags_user_team" FROM S3Object s`
+	if actual := query.sql(formatParquet); actual != expected {
+		t.Errorf("Expected query %s but got %s", expected, actual)
+	}
+}
+
+func TestParquetSQL(t *testing.T) {
+	query, err := newReportQuery(testColumns, []string{columnLineItemID, columnUsageStart}, []string{"resourceTags/user:team"})
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	expected := `SELECT s."identity_line_item_id", s."line_item_usage_start_date", s."resource_tags_user_team" FROM S3Object s`
+	if actual := query.sql(formatParquet); actual != expected {
+		t.Errorf("Expected query %s but got %s", expected, actual)
+	}
+}
+
+func TestParquetColumnName(t *testing.T) {
+	cases := map[string]string{
+		columnBlendedCost:                  "line_item_blended_cost",
+		"savingsPlan/SavingsPlanARN":       "savings_plan_savings_plan_arn",
+		"resourceTags/aws:createdBy":       "resource_tags_aws_created_by",
+		"pricing/publicOnDemandRate":       "pricing_public_on_demand_rate",
+		"reservation/AmortizedUpfrontFee1": "reservation_amortized_upfront_fee1",
+		// Names of CUR 2.0 are kept
+		"line_item_usage_start_date": "line_item_usage_start_date",
+		"bill_payer_account_id":      "bill_payer_account_id",
+	}
+
+	for name, want := range cases {
+		if actual := parquetColumnName(name); actual != want {
+			t.Errorf("Expected %s for %s but got %s", want, name, actual)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package aws
+
+import (
+	"fmt"
+)
+
+// Dimensions of the line items that can be added as labels
+const (
+	DimensionAccount   = "account"
+	DimensionRegion    = "region"
+	DimensionUsageType = "usage_type"
+	DimensionResource  = "resource"
+)
+
+var dimensionColumns = map[string]string{
+	DimensionAccount:   columnUsageAccountID,
+	DimensionRegion:    columnRegion,
+	DimensionUsageType: columnUsageType,
+	DimensionResource:  columnResourceID,
+}
+
+// labelColumn is a column of the report that is added as a label
+type labelColumn struct {
+	label  string
+	column string
+}
+
+// newLabelColumns returns the columns for the dimensions and cost allocation tags.
+// A tag is added as a label with the name of the tag, e.g. team for resourceTags/user:team.
+func newLabelColumns(dimensions []string, tags []string) ([]labelColumn, error) {
+	labels := make([]labelColumn, 0, len(dimensions)+len(tags))
+	// The labels that every line item has
+	used := map[string]bool{"service": true, "currency": true, "cloud": true, "line_item_type": true, "payer_account": true}
+	add := func(label, column string) error {
+		if used[label] {
+			return fmt.Errorf("label %q is already used", label)
+		}
+		used[label] = true
+		labels = append(labels, labelColumn{label: label, column: column})
+		return nil
+	}
+
+	for _, dimension := range dimensions {
+		column, ok := dimensionColumns[dimension]
+		if !ok {
+			return nil, fmt.Errorf("unknown dimension %q", dimension)
+		}
+		if err := add(dimension, column); err != nil {
+			return nil, err
+		}
+	}
+	for _, tag := range tags {
+		if tag == "" {
+			return nil, fmt.Errorf("empty tag name")
+		}
+		if err := add(tag, columnTagPrefix+tag); err != nil {
+			return nil, err
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var dimensionsReport = `identity/LineItemId,lineItem/UsageAccountId,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/UsageType,lineItem/ResourceId,lineItem/CurrencyCode,lineItem/BlendedCost,product/region,resourceTags/user:team,resourceTags/user:owner
+id1,111111111111,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonEC2,EU-BoxUsage:t2.micro,i-1,USD,1,eu-west-1,platform,alice
+id2,222222222222,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonS3,EU-TimedStorage-ByteHrs,bucket,USD,2,eu-west-1,,bob
+`
+
+func TestDimensionLabels(t *testing.T) {
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{
+		Dimensions: []string{DimensionAccount, DimensionRegion, DimensionUsageType, DimensionResource},
+		Tags:       []string{"team", "project"},
+	}})
+
+	data, err := client.ReadReport("report.csv", strings.NewReader(dimensionsReport), timestamp)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	if len(data) != 2 {
+		t.Fatalf("Expected 2 line items but got %v", data)
+	}
+
+	expected := map[string]string{
+		"account":    "111111111111",
+		"region":     "eu-west-1",
+		"usage_type": "EU-BoxUsage:t2.micro",
+		"resource":   "i-1",
+		"team":       "platform",
+	}
+	for label, want := range expected {
+		if actual := data[0].Labels[label]; actual != want {
+			t.Errorf("Expected %s=%s but got %s=%s", label, want, label, actual)
+		}
+	}
+
+	// Tags that are not allowed, missing or empty are left out
+	for _, label := range []string{"owner", "project"} {
+		if _, ok := data[0].Labels[label]; ok {
+			t.Errorf("Expected no label %s but got %v", label, data[0].Labels)
+		}
+	}
+	if _, ok := data[1].Labels["team"]; ok {
+		t.Errorf("Expected no label team for an untagged resource but got %v", data[1].Labels)
+	}
+}
+
+func TestNewLabelColumns(t *testing.T) {
+	labels, err := newLabelColumns([]string{DimensionAccount}, []string{"team"})
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	expected := []labelColumn{{"account", columnUsageAccountID}, {"team", "resourceTags/user:team"}}
+	if len(labels) != len(expected) || labels[0] != expected[0] || labels[1] != expected[1] {
+		t.Errorf("Expected %v but got %v", expected, labels)
+	}
+
+	cases := []struct {
+		dimensions []string
+		tags       []string
+	}{
+		{[]string{"availability_zone"}, nil},
+		{nil, []string{""}},
+		{nil, []string{"service"}},
+		{[]string{DimensionRegion}, []string{"region"}},
+	}
+	for _, c := range cases {
+		if _, err := newLabelColumns(c.dimensions, c.tags); err == nil {
+			t.Errorf("Expected error for dimensions %v and tags %v but got none!", c.dimensions, c.tags)
+		}
+	}
+}
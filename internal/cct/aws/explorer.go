@@ -0,0 +1,246 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Cost Explorer API is only served from us-east-1
+const explorerRegion = "us-east-1"
+
+const (
+	explorerDayFormat  = "2006-01-02"
+	explorerHourFormat = "2006-01-02T15:04:05Z"
+)
+
+// Cost Explorer limits the number of groups in a request
+const maxExplorerGroups = 2
+
+// ExplorerConfig describes how the Cost Explorer API is used
+type ExplorerConfig struct {
+	SessionConfig
+	// PayerAccount is written as the payer_account label if set
+	PayerAccount string
+	// GroupBy lists the labels that the cost is grouped by, at most two.
+	// DimensionAccount, DimensionRegion, DimensionUsageType and service are dimensions, other names are tag keys.
+	// Empty means service.
+	GroupBy []string
+	// CostMetrics lists the metrics to read, e.g. MetricAmortized. MetricPublicOnDemand is not available.
+	// Empty means blended cost.
+	CostMetrics []string
+	// Hourly reads the cost of every hour of the day. The hourly granularity must be enabled for the account.
+	Hourly bool
+}
+
+// explorerMetrics maps the cost metrics to the metrics of Cost Explorer
+var explorerMetrics = map[string]string{
+	MetricUnblended:    "UnblendedCost",
+	MetricBlended:      "BlendedCost",
+	MetricNetUnblended: "NetUnblendedCost",
+	MetricAmortized:    "AmortizedCost",
+}
+
+// explorerDimensions maps the labels to the dimensions of Cost Explorer
+var explorerDimensions = map[string]string{
+	"service":          costexplorer.DimensionService,
+	DimensionAccount:   costexplorer.DimensionLinkedAccount,
+	DimensionRegion:    costexplorer.DimensionRegion,
+	DimensionUsageType: costexplorer.DimensionUsageType,
+}
+
+// explorerAPI is the part of the Cost Explorer service that is used, to simplify testing
+type explorerAPI interface {
+	GetCostAndUsageWithContext(ctx aws.Context, input *costexplorer.GetCostAndUsageInput, opts ...request.Option) (*costexplorer.GetCostAndUsageOutput, error)
+}
+
+// ExplorerClient reads the cost from the Cost Explorer API instead of the Cost and Usage Report
+type ExplorerClient struct {
+	service      explorerAPI
+	groupBy      []string
+	metrics      []string
+	hourly       bool
+	payerAccount string
+}
+
+// NewExplorerClient initializes a new Cost Explorer connection
+func NewExplorerClient(config ExplorerConfig) ExplorerClient {
+	sess, err := newSession(config.SessionConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := newExplorerClient(costexplorer.New(sess, serviceConfig(explorerRegion, config.SessionConfig)), config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client
+}
+
+func newExplorerClient(service explorerAPI, config ExplorerConfig) (ExplorerClient, error) {
+	groupBy := config.GroupBy
+	if len(groupBy) == 0 {
+		groupBy = []string{"service"}
+	}
+	if len(groupBy) > maxExplorerGroups {
+		return ExplorerClient{}, fmt.Errorf("cost explorer can group by at most %d labels, got %v", maxExplorerGroups, groupBy)
+	}
+
+	names := config.CostMetrics
+	if len(names) == 0 {
+		names = []string{MetricBlended}
+	}
+	metrics := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := explorerMetrics[name]; !ok {
+			return ExplorerClient{}, fmt.Errorf("cost metric %q is not available in cost explorer", name)
+		}
+		metrics = append(metrics, name)
+	}
+
+	return ExplorerClient{
+		service:      service,
+		groupBy:      groupBy,
+		metrics:      metrics,
+		hourly:       config.Hourly,
+		payerAccount: config.PayerAccount,
+	}, nil
+}
+
+// GetCloudCost returns the cost of the day of the timestamp in UTC, grouped by the labels of the client
+func (client *ExplorerClient) GetCloudCost(ctx context.Context, timestamp time.Time) ([]dbclient.UsageData, error) {
+	day := time.Date(timestamp.Year(), timestamp.Month(), timestamp.Day(), 0, 0, 0, 0, time.UTC)
+	input := client.costAndUsageInput(day)
+
+	var data []dbclient.UsageData
+	for {
+		output, err := client.service.GetCostAndUsageWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range output.ResultsByTime {
+			usage, err := client.usageData(result, day)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, usage...)
+		}
+		if aws.StringValue(output.NextPageToken) == "" {
+			return data, nil
+		}
+		input.NextPageToken = output.NextPageToken
+	}
+}
+
+// costAndUsageInput returns the request for the cost of the day
+func (client *ExplorerClient) costAndUsageInput(day time.Time) *costexplorer.GetCostAndUsageInput {
+	granularity, format := costexplorer.GranularityDaily, explorerDayFormat
+	if client.hourly {
+		granularity, format = costexplorer.GranularityHourly, explorerHourFormat
+	}
+
+	input := &costexplorer.GetCostAndUsageInput{
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(day.Format(format)),
+			End:   aws.String(day.AddDate(0, 0, 1).Format(format)),
+		},
+		Granularity: aws.String(granularity),
+	}
+	for _, metric := range client.metrics {
+		input.Metrics = append(input.Metrics, aws.String(explorerMetrics[metric]))
+	}
+	for _, label := range client.groupBy {
+		group := &costexplorer.GroupDefinition{Key: aws.String(label), Type: aws.String(costexplorer.GroupDefinitionTypeTag)}
+		if dimension, ok := explorerDimensions[label]; ok {
+			group = &costexplorer.GroupDefinition{Key: aws.String(dimension), Type: aws.String(costexplorer.GroupDefinitionTypeDimension)}
+		}
+		input.GroupBy = append(input.GroupBy, group)
+	}
+	return input
+}
+
+// usageData returns one UsageData for every group of the result
+func (client *ExplorerClient) usageData(result *costexplorer.ResultByTime, day time.Time) ([]dbclient.UsageData, error) {
+	date := day
+	if client.hourly && result.TimePeriod != nil {
+		start, err := time.Parse(explorerHourFormat, aws.StringValue(result.TimePeriod.Start))
+		if err != nil {
+			return nil, err
+		}
+		date = start
+	}
+
+	// Without groups the cost is only in the total
+	if len(result.Groups) == 0 {
+		if len(result.Total) == 0 {
+			return nil, nil
+		}
+		usage, err := client.metricUsage(result.Total, date)
+		if err != nil {
+			return nil, err
+		}
+		return []dbclient.UsageData{usage}, nil
+	}
+
+	data := make([]dbclient.UsageData, 0, len(result.Groups))
+	for _, group := range result.Groups {
+		usage, err := client.metricUsage(group.Metrics, date)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range group.Keys {
+			if i >= len(client.groupBy) {
+				break
+			}
+			value := aws.StringValue(key)
+			if _, ok := explorerDimensions[client.groupBy[i]]; !ok {
+				// Tag keys are returned as key$value, with an empty value for untagged cost
+				value = value[strings.Index(value, "$")+1:]
+			}
+			if value != "" {
+				usage.Labels[client.groupBy[i]] = value
+			}
+		}
+		data = append(data, usage)
+	}
+	return data, nil
+}
+
+// metricUsage returns the UsageData of the metrics of a group
+func (client *ExplorerClient) metricUsage(values map[string]*costexplorer.MetricValue, date time.Time) (dbclient.UsageData, error) {
+	usage := dbclient.UsageData{
+		Date:   date,
+		Labels: map[string]string{"cloud": "aws"},
+	}
+	if client.payerAccount != "" {
+		usage.Labels["payer_account"] = client.payerAccount
+	}
+	if len(client.metrics) > 1 {
+		usage.Fields = make(map[string]float64, len(client.metrics))
+	}
+
+	for i, metric := range client.metrics {
+		value, ok := values[explorerMetrics[metric]]
+		if !ok || value == nil {
+			return usage, fmt.Errorf("metric %s missing from cost explorer result", explorerMetrics[metric])
+		}
+		cost, err := strconv.ParseFloat(aws.StringValue(value.Amount), 64)
+		if err != nil {
+			return usage, fmt.Errorf("metric %s: %v", explorerMetrics[metric], err)
+		}
+		if i == 0 {
+			usage.Cost = cost
+			usage.Labels["currency"] = aws.StringValue(value.Unit)
+		}
+		if usage.Fields != nil {
+			usage.Fields[metric+"_cost"] = cost
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,177 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+// explorerPages are the responses of the fake Cost Explorer endpoint, by page token
+var explorerPages = map[string]string{
+	"": `{
+		"ResultsByTime": [{
+			"TimePeriod": {"Start": "2018-08-01", "End": "2018-08-02"},
+			"Groups": [
+				{"Keys": ["AmazonEC2", "team$platform"], "Metrics": {"AmortizedCost": {"Amount": "1.5", "Unit": "USD"}, "UnblendedCost": {"Amount": "2", "Unit": "USD"}}},
+				{"Keys": ["AmazonS3", "team$"], "Metrics": {"AmortizedCost": {"Amount": "0.25", "Unit": "USD"}, "UnblendedCost": {"Amount": "0.25", "Unit": "USD"}}}
+			]
+		}],
+		"NextPageToken": "page2"
+	}`,
+	"page2": `{
+		"ResultsByTime": [{
+			"TimePeriod": {"Start": "2018-08-01", "End": "2018-08-02"},
+			"Groups": [
+				{"Keys": ["AWSLambda", "team$data"], "Metrics": {"AmortizedCost": {"Amount": "0.1", "Unit": "USD"}, "UnblendedCost": {"Amount": "0.1", "Unit": "USD"}}}
+			]
+		}]
+	}`,
+}
+
+// newFakeExplorer starts a local stand-in of the Cost Explorer endpoint that records the requests
+func newFakeExplorer(t *testing.T, requests *[]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "AWSInsightsIndexService.GetCostAndUsage" {
+			t.Errorf("Expected target AWSInsightsIndexService.GetCostAndUsage but got %s", target)
+		}
+		var request map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Caught error: %s", err)
+		}
+		*requests = append(*requests, request)
+
+		token, _ := request["NextPageToken"].(string)
+		page, ok := explorerPages[token]
+		if !ok {
+			http.Error(w, `{"__type": "InvalidNextTokenException"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(page))
+	}))
+}
+
+func TestExplorerGetCloudCost(t *testing.T) {
+	var requests []map[string]interface{}
+	server := newFakeExplorer(t, &requests)
+	defer server.Close()
+
+	client := NewExplorerClient(ExplorerConfig{
+		SessionConfig: SessionConfig{AccessKeyID: "id", SecretAccessKey: "secret", Endpoint: server.URL},
+		GroupBy:       []string{"service", "team"},
+		CostMetrics:   []string{MetricAmortized, MetricUnblended},
+	})
+	timestamp := time.Date(2018, time.August, 1, 13, 0, 0, 0, time.UTC)
+	data, err := client.GetCloudCost(context.Background(), timestamp)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	t.Run("Pagination", func(t *testing.T) {
+		if len(requests) != 2 {
+			t.Fatalf("Expected 2 requests but got %d", len(requests))
+		}
+		if token := requests[1]["NextPageToken"]; token != "page2" {
+			t.Errorf("Expected token page2 but got %v", token)
+		}
+		if len(data) != 3 {
+			t.Errorf("Expected 3 groups from both pages but got %v", data)
+		}
+	})
+
+	t.Run("Request", func(t *testing.T) {
+		period, _ := requests[0]["TimePeriod"].(map[string]interface{})
+		if period["Start"] != "2018-08-01" || period["End"] != "2018-08-02" {
+			t.Errorf("Expected the period 2018-08-01 to 2018-08-02 but got %v", period)
+		}
+		if granularity := requests[0]["Granularity"]; granularity != costexplorer.GranularityDaily {
+			t.Errorf("Expected granularity DAILY but got %v", granularity)
+		}
+		groups, _ := requests[0]["GroupBy"].([]interface{})
+		if len(groups) != 2 {
+			t.Fatalf("Expected 2 groups but got %v", groups)
+		}
+		service, _ := groups[0].(map[string]interface{})
+		tag, _ := groups[1].(map[string]interface{})
+		if service["Type"] != "DIMENSION" || service["Key"] != "SERVICE" || tag["Type"] != "TAG" || tag["Key"] != "team" {
+			t.Errorf("Expected the service dimension and the team tag but got %v", groups)
+		}
+	})
+
+	t.Run("Usage data", func(t *testing.T) {
+		ec2 := data[0]
+		if ec2.Labels["service"] != "AmazonEC2" || ec2.Labels["team"] != "platform" || ec2.Labels["currency"] != "USD" || ec2.Labels["cloud"] != "aws" {
+			t.Errorf("Expected labels of AmazonEC2 but got %v", ec2.Labels)
+		}
+		if ec2.Cost != 1.5 || ec2.Fields["amortized_cost"] != 1.5 || ec2.Fields["unblended_cost"] != 2 {
+			t.Errorf("Expected amortized cost 1.5 and unblended cost 2 but got %v and %v", ec2.Cost, ec2.Fields)
+		}
+		if !ec2.Date.Equal(time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected the start of the day but got %v", ec2.Date)
+		}
+		// Untagged cost has no tag label
+		if _, ok := data[1].Labels["team"]; ok {
+			t.Errorf("Expected no team label for untagged cost but got %v", data[1].Labels)
+		}
+	})
+}
+
+func TestExplorerCancelled(t *testing.T) {
+	var requests []map[string]interface{}
+	server := newFakeExplorer(t, &requests)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := NewExplorerClient(ExplorerConfig{SessionConfig: SessionConfig{AccessKeyID: "id", SecretAccessKey: "secret", Endpoint: server.URL}})
+	if _, err := client.GetCloudCost(ctx, time.Now()); err == nil {
+		t.Errorf("Expected error but got none!")
+	}
+	if len(requests) != 0 {
+		t.Errorf("Expected no requests but got %v", requests)
+	}
+}
+
+func TestExplorerHourly(t *testing.T) {
+	client, err := newExplorerClient(nil, ExplorerConfig{Hourly: true, PayerAccount: "main"})
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	day := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+
+	input := client.costAndUsageInput(day)
+	if aws.StringValue(input.TimePeriod.Start) != "2018-08-01T00:00:00Z" || aws.StringValue(input.Granularity) != costexplorer.GranularityHourly {
+		t.Errorf("Expected an hourly request from 2018-08-01T00:00:00Z but got %v", input)
+	}
+
+	result := &costexplorer.ResultByTime{
+		TimePeriod: &costexplorer.DateInterval{Start: aws.String("2018-08-01T13:00:00Z")},
+		Total:      map[string]*costexplorer.MetricValue{"BlendedCost": {Amount: aws.String("0.5"), Unit: aws.String("USD")}},
+	}
+	data, err := client.usageData(result, day)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	if len(data) != 1 || data[0].Date.Hour() != 13 || data[0].Cost != 0.5 || data[0].Labels["payer_account"] != "main" {
+		t.Errorf("Expected cost 0.5 of payer account main at 13:00 but got %v", data)
+	}
+}
+
+func TestNewExplorerClient(t *testing.T) {
+	cases := []ExplorerConfig{
+		{GroupBy: []string{"service", "account", "team"}},
+		{CostMetrics: []string{MetricPublicOnDemand}},
+		{CostMetrics: []string{"list_price"}},
+	}
+	for _, c := range cases {
+		if _, err := newExplorerClient(nil, c); err == nil {
+			t.Errorf("Expected error for %v but got none!", c)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package aws
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+)
+
+// FileConfig describes a directory of Cost and Usage Report files, e.g. downloaded from the bucket
+type FileConfig struct {
+	// Directory containing the report files in CSV format, optionally compressed with gzip (.csv.gz) or zip (.zip)
+	Directory string
+	IngestionConfig
+}
+
+// FileClient reads the Cost and Usage Report from local files instead of S3
+type FileClient struct {
+	directory string
+	ingestion
+}
+
+// NewFileClient creates a client reading the report files in a directory
+func NewFileClient(config FileConfig) FileClient {
+	return FileClient{
+		directory: config.Directory,
+		ingestion: newIngestion(config.IngestionConfig),
+	}
+}
+
+// GetCloudCost returns information about the cost during a specific day from all report files in the directory.
+// The day is taken in the billing time zone. Stops before the next file if the context is done.
+func (client *FileClient) GetCloudCost(ctx context.Context, timestamp time.Time) ([]dbclient.UsageData, error) {
+	day := client.day(timestamp)
+	names, err := reportFiles(client.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	report := reportRows{ingestion: &client.ingestion}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		err = report.read(name, file, day)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client.finish(report.data, report.rows, report.errs)
+}
+
+// ReadReport returns information about the cost during a specific day from a single report file.
+// The name is only used to detect the compression and in errors.
+func (client *FileClient) ReadReport(name string, r io.Reader, timestamp time.Time) ([]dbclient.UsageData, error) {
+	report := reportRows{ingestion: &client.ingestion}
+	if err := report.read(name, r, client.day(timestamp)); err != nil {
+		return nil, err
+	}
+	return client.finish(report.data, report.rows, report.errs)
+}
+
+// reportFiles returns the report files in the directory in alphabetical order
+func reportFiles(directory string) ([]string, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read report directory: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isReportFile(entry.Name()) {
+			names = append(names, filepath.Join(directory, entry.Name()))
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("there are no report files in %s", directory)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func isReportFile(name string) bool {
+	return strings.HasSuffix(name, ".csv") || strings.HasSuffix(name, ".csv.gz") || strings.HasSuffix(name, ".zip")
+}
+
+// reportRows collects the UsageData and row errors of all parts of a report
+type reportRows struct {
+	ingestion *ingestion
+	data      []dbclient.UsageData
+	rows      int
+	errs      []RowError
+}
+
+// read decompresses a report file according to its name and reads all the rows
+func (report *reportRows) read(name string, r io.Reader, timestamp time.Time) error {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", name, err)
+		}
+		defer gz.Close()
+		return report.readCSV(name, gz, timestamp)
+	case strings.HasSuffix(name, ".zip"):
+		return report.readZip(name, r, timestamp)
+	default:
+		return report.readCSV(name, r, timestamp)
+	}
+}
+
+// readZip reads every CSV file in the zip archive
+func (report *reportRows) readZip(name string, r io.Reader, timestamp time.Time) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", name, err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", name, err)
+	}
+
+	for _, file := range archive.File {
+		if !strings.HasSuffix(file.Name, ".csv") {
+			continue
+		}
+		part, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("unable to read %s in %s: %v", file.Name, name, err)
+		}
+		err = report.readCSV(name+"/"+file.Name, part, timestamp)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCSV reads a report part with a header naming the columns, e.g. lineItem/UsageStartDate.
+// The columns are selected by name in the same way as with S3 Select.
+func (report *reportRows) readCSV(key string, r io.Reader, timestamp time.Time) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("unable to read the header of %s: %v", key, err)
+	}
+
+	query, err := report.ingestion.newQuery(headerColumns(header))
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+
+	tbl := make([]reportRecord, 0)
+	var errs []RowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			errs = append(errs, RowError{Key: key, Row: perr.StartLine, Err: perr.Err})
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %v", key, err)
+		}
+		line, _ := reader.FieldPos(0)
+		tbl = append(tbl, reportRecord{row: line, values: query.selectColumns(record)})
+	}
+
+	report.add(key, query, tbl, errs, timestamp)
+	return nil
+}
+
+// add transforms the records of a report part into UsageData for the day and collects them with the errors
+// from reading the part
+func (report *reportRows) add(key string, query *reportQuery, records []reportRecord, errs []RowError, day time.Time) {
+	data, dataErrs := report.ingestion.parseRecords(key, query, records, day)
+	report.data = append(report.data, data...)
+	report.errs = append(append(report.errs, errs...), dataErrs...)
+	report.rows += len(records) + len(errs)
+}
+
+// headerColumns returns the columns named in the header of a report
+func headerColumns(header []string) []manifestColumn {
+	columns := make([]manifestColumn, len(header))
+	for i, name := range header {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) == 2 {
+			columns[i] = manifestColumn{Category: parts[0], Name: parts[1]}
+		} else {
+			columns[i] = manifestColumn{Name: name}
+		}
+	}
+	return columns
+}
@@ -0,0 +1,159 @@
+package aws
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+)
+
+const fixtureName = "test-usage-report-1.csv"
+
+func readFixture(t *testing.T) []byte {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", fixtureName))
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	return content
+}
+
+func gzipFixture(t *testing.T) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(readFixture(t))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	return b.Bytes()
+}
+
+func zipFixture(t *testing.T) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	f, err := w.Create(fixtureName)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	f.Write(readFixture(t))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	return b.Bytes()
+}
+
+// checkFixtureCost checks the cost of the fixture on 2018-08-01 when grouped by service
+func checkFixtureCost(t *testing.T, data []dbclient.UsageData) {
+	expected := map[string]float64{"AmazonS3": 1.5, "AmazonEC2": 2}
+	if len(data) != len(expected) {
+		t.Fatalf("Expected %d services but got %v", len(expected), data)
+	}
+	for _, row := range data {
+		service := row.Labels["service"]
+		if row.Cost != expected[service] {
+			t.Errorf("Expected cost %v for %s but got %v", expected[service], service, row.Cost)
+		}
+	}
+}
+
+func TestReadReport(t *testing.T) {
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{GroupBy: []string{"service"}}})
+
+	cases := map[string][]byte{
+		fixtureName:             readFixture(t),
+		fixtureName + ".gz":     gzipFixture(t),
+		"test-usage-report.zip": zipFixture(t),
+	}
+
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			data, err := client.ReadReport(name, bytes.NewReader(content), timestamp)
+			if err != nil {
+				t.Fatalf("Caught error: %s", err)
+			}
+			checkFixtureCost(t, data)
+		})
+	}
+}
+
+func TestReadReportStrict(t *testing.T) {
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{Strict: true}})
+
+	_, err := client.ReadReport(fixtureName, bytes.NewReader(readFixture(t)), timestamp)
+	ierr, ok := err.(*IngestionError)
+	if !ok {
+		t.Fatalf("Expected IngestionError but got %v", err)
+	}
+	if ierr.Rows != 3 || len(ierr.Errors) != 1 || ierr.Errors[0].Column != columnBlendedCost {
+		t.Errorf("Expected the cost of one row of 3 in the error but got %+v", ierr)
+	}
+}
+
+func TestReadReportMissingColumn(t *testing.T) {
+	client := NewFileClient(FileConfig{})
+	content := "identity/LineItemId,lineItem/UsageStartDate\nid1,2018-08-01T00:00:00Z\n"
+
+	_, err := client.ReadReport("report.csv", strings.NewReader(content), time.Now())
+	if err == nil || !strings.Contains(err.Error(), columnBlendedCost) {
+		t.Errorf("Expected error naming %s but got %v", columnBlendedCost, err)
+	}
+}
+
+func TestFileClientGetCloudCost(t *testing.T) {
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Report files in directory", func(t *testing.T) {
+		directory, err := ioutil.TempDir("", "cct")
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		defer os.RemoveAll(directory)
+		ioutil.WriteFile(filepath.Join(directory, fixtureName+".gz"), gzipFixture(t), 0644)
+		ioutil.WriteFile(filepath.Join(directory, "test-usage-report-Manifest.json"), []byte(manifestJSON), 0644)
+
+		client := NewFileClient(FileConfig{Directory: directory, IngestionConfig: IngestionConfig{GroupBy: []string{"service"}}})
+		data, err := client.GetCloudCost(context.Background(), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		checkFixtureCost(t, data)
+	})
+
+	t.Run("No report files", func(t *testing.T) {
+		directory, err := ioutil.TempDir("", "cct")
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		defer os.RemoveAll(directory)
+
+		client := NewFileClient(FileConfig{Directory: directory})
+		if _, err := client.GetCloudCost(context.Background(), timestamp); err == nil {
+			t.Errorf("Expected error but got none!")
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		directory, err := ioutil.TempDir("", "cct")
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		defer os.RemoveAll(directory)
+		ioutil.WriteFile(filepath.Join(directory, fixtureName+".gz"), gzipFixture(t), 0644)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		client := NewFileClient(FileConfig{Directory: directory})
+		if _, err := client.GetCloudCost(ctx, timestamp); err != context.Canceled {
+			t.Errorf("Expected %v but got %v", context.Canceled, err)
+		}
+	})
+}
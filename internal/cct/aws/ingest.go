@@ -0,0 +1,279 @@
+package aws
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+)
+
+// The format of dates in the report
+const reportTimeFormat = "2006-01-02T15:04:05Z"
+
+// RowError describes a row of the report that could not be read
+type RowError struct {
+	// Key of the report part
+	Key string
+	// Row is the line of the row in a CSV report part, counting the header, or the row number in a Parquet part
+	Row int
+	// Column and Value are empty if the whole row is malformed
+	Column string
+	Value  string
+	Err    error
+}
+
+func (e RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("%s row %d: %v", e.Key, e.Row, e.Err)
+	}
+	return fmt.Sprintf("%s row %d column %s value %q: %v", e.Key, e.Row, e.Column, e.Value, e.Err)
+}
+
+// IngestionError is returned in strict mode when more rows than tolerated could not be read
+type IngestionError struct {
+	// Rows is the number of rows in the report
+	Rows int
+	// Errors has all errors of the rows, a row can have more than one
+	Errors []RowError
+}
+
+func (e *IngestionError) Error() string {
+	return fmt.Sprintf("%d of %d rows in the report could not be read, the first one is %v", failedRows(e.Errors), e.Rows, e.Errors[0])
+}
+
+// failedRows returns the number of distinct rows that have errors
+func failedRows(errs []RowError) int {
+	type row struct {
+		key string
+		row int
+	}
+	rows := make(map[row]bool)
+	for _, err := range errs {
+		rows[row{err.Key, err.Row}] = true
+	}
+	return len(rows)
+}
+
+// reportRecord is a record of a report part together with its row, see RowError
+type reportRecord struct {
+	row    int
+	values []string
+}
+
+// rowParser reads values from a record and collects the errors
+type rowParser struct {
+	key    string
+	row    int
+	query  *reportQuery
+	record []string
+	errs   []RowError
+}
+
+func (p *rowParser) addError(column string, value string, err error) {
+	p.errs = append(p.errs, RowError{Key: p.key, Row: p.row, Column: column, Value: value, Err: err})
+}
+
+func (p *rowParser) string(column string) string {
+	return p.query.value(p.record, column)
+}
+
+func (p *rowParser) float(column string) float64 {
+	value := p.query.value(p.record, column)
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		p.addError(column, value, err)
+	}
+	return f
+}
+
+// optionalFloat returns 0 if the column is not in the report or empty
+func (p *rowParser) optionalFloat(column string) float64 {
+	if p.string(column) == "" {
+		return 0
+	}
+	return p.float(column)
+}
+
+func (p *rowParser) time(column string) time.Time {
+	value := p.query.value(p.record, column)
+	t, err := time.Parse(reportTimeFormat, value)
+	if err != nil {
+		p.addError(column, value, err)
+	}
+	return t
+}
+
+// ingestion holds the options for turning the rows of a report into UsageData,
+// no matter where the report is read from
+type ingestion struct {
+	groupBy      []string
+	strict       bool
+	maxRowErrors int
+	// The first metric is written as the cost
+	metrics []costMetric
+	labels  []labelColumn
+	// periods splits a line item into the points that are written for the day
+	periods func(lineItemType string, start time.Time, stop time.Time, date time.Time) []usagePeriod
+	// location is the billing time zone
+	location *time.Location
+	// payerAccount replaces the payer account of the report if set
+	payerAccount string
+}
+
+// newIngestion creates the ingestion options. The cost metrics default to blended cost.
+func newIngestion(config IngestionConfig) ingestion {
+	metrics, err := newCostMetrics(config.CostMetrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+	labels, err := newLabelColumns(config.Dimensions, config.Tags)
+	if err != nil {
+		log.Fatal(err)
+	}
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	periods := dailyPeriods
+	if config.Hourly {
+		periods = hourlyPeriods
+	}
+	return ingestion{
+		groupBy:      config.GroupBy,
+		strict:       config.Strict,
+		maxRowErrors: config.MaxRowErrors,
+		metrics:      metrics,
+		labels:       labels,
+		periods:      periods,
+		location:     location,
+		payerAccount: config.PayerAccount,
+	}
+}
+
+// day returns the start of the day of the timestamp in the billing time zone.
+// Only the date of the timestamp is used, so 2018-08-01 is the same day no matter the time zone it is given in.
+func (in *ingestion) day(timestamp time.Time) time.Time {
+	return time.Date(timestamp.Year(), timestamp.Month(), timestamp.Day(), 0, 0, 0, 0, in.location)
+}
+
+// newQuery creates a query for the columns needed by the line items and cost metrics
+func (in *ingestion) newQuery(columns []manifestColumn) (*reportQuery, error) {
+	required := append([]string{}, requiredColumns...)
+	optional := append([]string{}, optionalColumns...)
+	for _, metric := range in.metrics {
+		required = append(required, metric.required...)
+		optional = append(optional, metric.optional...)
+	}
+	// Tags are only in the report if they have been activated
+	for _, label := range in.labels {
+		optional = append(optional, label.column)
+	}
+	return newReportQuery(columns, required, optional)
+}
+
+// parseRecords transforms records of a report part into UsageData for the day of the timestamp.
+// Rows that can't be read are skipped and returned as errors. Empty dimensions and tags are left out of the labels.
+// The line item type is added as a label if the report has it, and decides how the cost is counted.
+// If more than one cost metric is used, all of them are written as fields.
+func (in *ingestion) parseRecords(key string, query *reportQuery, records []reportRecord, timestamp time.Time) ([]dbclient.UsageData, []RowError) {
+	res := make([]dbclient.UsageData, 0)
+	var errs []RowError
+
+	for _, record := range records {
+		p := rowParser{key: key, row: record.row, query: query, record: record.values}
+
+		// The line item ID is unique, so it is not a label
+		attributes := map[string]string{"line_item_id": p.string(columnLineItemID)}
+		labels := map[string]string{}
+		labels["service"] = p.string(columnProductCode)
+		labels["currency"] = p.string(columnCurrency)
+		labels["cloud"] = "aws"
+		lineItemType := p.string(columnLineItemType)
+		if lineItemType != "" {
+			labels["line_item_type"] = lineItemType
+		}
+		payerAccount := in.payerAccount
+		if payerAccount == "" {
+			payerAccount = p.string(columnPayerAccountID)
+		}
+		if payerAccount != "" {
+			labels["payer_account"] = payerAccount
+		}
+		for _, label := range in.labels {
+			if value := p.string(label.column); value != "" {
+				labels[label.label] = value
+			}
+		}
+		start := p.time(columnUsageStart)
+		stop := p.time(columnUsageEnd)
+		costs := make([]float64, len(in.metrics))
+		for j, metric := range in.metrics {
+			costs[j] = metric.cost(&p)
+		}
+		var amount float64
+		if query.has(columnUsageAmount) {
+			amount = p.float(columnUsageAmount)
+		}
+		// One-time fees have no usage period
+		if len(p.errs) == 0 && !stop.After(start) && !isOneTime(lineItemType) {
+			p.addError(columnUsageEnd, p.string(columnUsageEnd), fmt.Errorf("usage ends before it starts at %s", p.string(columnUsageStart)))
+		}
+
+		if len(p.errs) > 0 {
+			errs = append(errs, p.errs...)
+			continue
+		}
+
+		for j := range costs {
+			costs[j] = lineItemCost(lineItemType, costs[j])
+		}
+		for _, period := range in.periods(lineItemType, start, stop, timestamp) {
+			fields := map[string]float64{"line_items": 1}
+			if query.has(columnUsageAmount) {
+				fields["usage_quantity"] = amount * period.ratio
+			}
+			if len(in.metrics) > 1 {
+				for j, metric := range in.metrics {
+					fields[metric.field()] = costs[j] * period.ratio
+				}
+			}
+			res = append(res, dbclient.UsageData{
+				Cost:       costs[0] * period.ratio,
+				Date:       period.date,
+				Labels:     labels,
+				Fields:     fields,
+				Attributes: attributes,
+			})
+		}
+	}
+
+	return res, errs
+}
+
+// finish checks the row errors and groups the UsageData read from all rows of the report
+func (in *ingestion) finish(data []dbclient.UsageData, rows int, errs []RowError) ([]dbclient.UsageData, error) {
+	if err := in.checkRowErrors(rows, errs); err != nil {
+		return nil, err
+	}
+
+	// Group similar UsageData
+	return groupUsageData(data, in.groupBy), nil
+}
+
+// checkRowErrors returns an IngestionError if the ingestion is strict and more rows than tolerated
+// could not be read. Otherwise the errors are only logged.
+func (in *ingestion) checkRowErrors(rows int, errs []RowError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	failed := failedRows(errs)
+	if in.strict && failed > in.maxRowErrors {
+		return &IngestionError{Rows: rows, Errors: errs}
+	}
+
+	log.Printf("Warning: skipped %d rows of %d in the report, the first one is %v", failed, rows, errs[0])
+	return nil
+}
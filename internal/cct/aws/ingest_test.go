@@ -0,0 +1,192 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecords(t *testing.T) {
+	in := newIngestion(IngestionConfig{})
+	query, err := in.newQuery(testColumns)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	records := []reportRecord{
+		{1, []string{"id1", "2018-08-01T00:00:00Z", "2018-08-01T01:00:00Z", "AmazonS3", "USD", "0.5"}},
+		{2, []string{"id2", "2018-08-01T00:00:00Z", "2018-08-01T01:00:00Z", "AmazonS3", "USD", "half"}},
+		{3, []string{"id3", "yesterday", "2018-08-01T01:00:00Z", "AmazonS3", "USD", "0.5"}},
+		{4, []string{"id4", "2018-08-01T01:00:00Z", "2018-08-01T01:00:00Z", "AmazonS3", "USD", "0.5"}},
+	}
+
+	data, errs := in.parseRecords("part-1.csv.gz", query, records, timestamp)
+	if len(data) != 1 || data[0].Attributes["line_item_id"] != "id1" || data[0].Cost != 0.5 {
+		t.Errorf("Expected only the first row but got %v", data)
+	}
+
+	expected := []RowError{
+		{Key: "part-1.csv.gz", Row: 2, Column: columnBlendedCost, Value: "half"},
+		{Key: "part-1.csv.gz", Row: 3, Column: columnUsageStart, Value: "yesterday"},
+		{Key: "part-1.csv.gz", Row: 4, Column: columnUsageEnd, Value: "2018-08-01T01:00:00Z"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors but got %v", len(expected), errs)
+	}
+	for i, e := range expected {
+		actual := errs[i]
+		if actual.Key != e.Key || actual.Row != e.Row || actual.Column != e.Column || actual.Value != e.Value || actual.Err == nil {
+			t.Errorf("Expected error %+v but got %+v", e, actual)
+		}
+	}
+}
+
+func TestCheckRowErrors(t *testing.T) {
+	errs := []RowError{{Key: "part-1.csv.gz", Row: 2}, {Key: "part-1.csv.gz", Row: 5}}
+	cases := []struct {
+		name      string
+		ingestion ingestion
+		fail      bool
+	}{
+		{"Not strict", ingestion{maxRowErrors: 0}, false},
+		{"Below threshold", ingestion{strict: true, maxRowErrors: 2}, false},
+		{"Above threshold", ingestion{strict: true, maxRowErrors: 1}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.ingestion.checkRowErrors(10, errs)
+			if c.fail {
+				ierr, ok := err.(*IngestionError)
+				if !ok {
+					t.Fatalf("Expected IngestionError but got %v", err)
+				}
+				if ierr.Rows != 10 || len(ierr.Errors) != 2 {
+					t.Errorf("Expected 2 of 10 rows in the error but got %+v", ierr)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error but got %v", err)
+			}
+		})
+	}
+
+	strict := ingestion{strict: true}
+	if err := strict.checkRowErrors(10, nil); err != nil {
+		t.Errorf("Expected no error without row errors but got %v", err)
+	}
+
+	// Rows are counted once no matter how many errors they have
+	oneRow := []RowError{{Key: "part-1.csv.gz", Row: 2, Column: columnUsageStart}, {Key: "part-1.csv.gz", Row: 2, Column: columnBlendedCost}}
+	tolerant := ingestion{strict: true, maxRowErrors: 1}
+	if err := tolerant.checkRowErrors(2, oneRow); err != nil {
+		t.Errorf("Expected no error with one bad row but got %v", err)
+	}
+	tolerant.maxRowErrors = 0
+	err := tolerant.checkRowErrors(2, oneRow)
+	if err == nil || !strings.HasPrefix(err.Error(), "1 of 2 rows") {
+		t.Errorf("Expected 1 of 2 rows in the error but got %v", err)
+	}
+}
+
+func TestReadReportRows(t *testing.T) {
+	// The third line is malformed and the fourth has a bad cost
+	report := `identity/LineItemId,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/CurrencyCode,lineItem/BlendedCost
+id1,2018-08-01T00:00:00Z,2018-08-01T01:00:00Z,AmazonS3,USD,0.5
+id2,2018-08-01T00:00:00Z,2018-08-01T01:00:00Z,AmazonS3
+id3,2018-08-01T00:00:00Z,2018-08-01T01:00:00Z,AmazonS3,USD,half
+`
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{Strict: true}})
+	_, err := client.ReadReport("report.csv", strings.NewReader(report), time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC))
+	ierr, ok := err.(*IngestionError)
+	if !ok {
+		t.Fatalf("Expected IngestionError but got %v", err)
+	}
+	rows := make(map[int]bool)
+	for _, e := range ierr.Errors {
+		rows[e.Row] = true
+	}
+	if len(ierr.Errors) != 2 || !rows[3] || !rows[4] {
+		t.Errorf("Expected errors on lines 3 and 4 but got %+v", ierr.Errors)
+	}
+}
+
+func TestDay(t *testing.T) {
+	in := newIngestion(IngestionConfig{Timezone: "Europe/Stockholm"})
+	timestamp := time.Date(2018, time.August, 1, 23, 30, 0, 0, time.UTC)
+
+	day := in.day(timestamp)
+	if day.Format(time.RFC3339) != "2018-08-01T00:00:00+02:00" {
+		t.Errorf("Expected the start of 2018-08-01 in Stockholm but got %v", day)
+	}
+
+	utc := newIngestion(IngestionConfig{})
+	if day := utc.day(timestamp); !day.Equal(time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the start of 2018-08-01 in UTC but got %v", day)
+	}
+}
+
+func TestReadReportHalfHourTimezone(t *testing.T) {
+	report := `identity/LineItemId,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/CurrencyCode,lineItem/BlendedCost
+august,2018-07-31T18:30:00Z,2018-08-01T18:30:00Z,AmazonEC2,USD,2
+`
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{Timezone: "Asia/Kolkata"}})
+	data, err := client.ReadReport("report.csv", strings.NewReader(report), time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	// Midnight is 18:30 UTC, the point is written at the next whole hour of the same day
+	if len(data) != 1 || !data[0].Date.Equal(time.Date(2018, time.July, 31, 19, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected one point at 19:00 UTC but got %v", data)
+	}
+}
+
+func TestReadReportTimezone(t *testing.T) {
+	// Line items from the end of July and the start of August in UTC
+	report := `identity/LineItemId,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/CurrencyCode,lineItem/BlendedCost
+july,2018-07-31T21:00:00Z,2018-07-31T23:00:00Z,AmazonEC2,USD,2
+august,2018-08-01T00:00:00Z,2018-08-01T01:00:00Z,AmazonEC2,USD,3
+`
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[string]float64{"": 3, "Europe/Stockholm": 4}
+
+	for timezone, want := range cases {
+		client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{Timezone: timezone, GroupBy: []string{"service"}}})
+		data, err := client.ReadReport("report.csv", strings.NewReader(report), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		if len(data) != 1 || !approxEqual(data[0].Cost, want) {
+			t.Fatalf("Expected cost %v in time zone %q but got %v", want, timezone, data)
+		}
+		if !data[0].Date.Equal(client.day(timestamp)) {
+			t.Errorf("Expected the point at the start of the day but got %v", data[0].Date)
+		}
+	}
+}
+
+func TestPayerAccount(t *testing.T) {
+	report := `identity/LineItemId,bill/PayerAccountId,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/CurrencyCode,lineItem/BlendedCost
+id1,111111111111,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonEC2,USD,1
+`
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		payerAccount string
+		want         string
+	}{
+		{"", "111111111111"},
+		{"other-org", "other-org"},
+	}
+
+	for _, c := range cases {
+		// The payer account is kept even when it is not grouped by
+		client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{PayerAccount: c.payerAccount, GroupBy: []string{"service"}}})
+		data, err := client.ReadReport("report.csv", strings.NewReader(report), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		if len(data) != 1 || data[0].Labels["payer_account"] != c.want {
+			t.Errorf("Expected payer_account %s but got %v", c.want, data)
+		}
+	}
+}
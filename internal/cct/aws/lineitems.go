@@ -0,0 +1,92 @@
+package aws
+
+import (
+	"math"
+	"time"
+)
+
+// Line item types that are not prorated usage.
+// See https://docs.aws.amazon.com/cur/latest/userguide/Lineitem-columns.html#Lineitem-details-L-LineItemType
+const (
+	lineItemFee                   = "Fee"
+	lineItemSavingsPlanUpfrontFee = "SavingsPlanUpfrontFee"
+	lineItemCredit                = "Credit"
+	lineItemRefund                = "Refund"
+)
+
+// isOneTime returns true for line items that are charged once instead of over the usage period
+func isOneTime(lineItemType string) bool {
+	return lineItemType == lineItemFee || lineItemType == lineItemSavingsPlanUpfrontFee
+}
+
+// lineItemRatio returns the part of a line item that belongs to the day of the date.
+// One-time fees, like the upfront fee of a reservation, are charged in full on the day they start
+// instead of being spread over the billing period.
+func lineItemRatio(lineItemType string, start time.Time, stop time.Time, date time.Time) float64 {
+	if isOneTime(lineItemType) {
+		start = start.In(date.Location())
+		if start.Year() == date.Year() && start.YearDay() == date.YearDay() {
+			return 1
+		}
+		return 0
+	}
+	return calculateRatio(start, stop, date)
+}
+
+// lineItemCost returns the cost with the sign of the line item type, credits and refunds always reduce the cost
+func lineItemCost(lineItemType string, cost float64) float64 {
+	if lineItemType == lineItemCredit || lineItemType == lineItemRefund {
+		return -math.Abs(cost)
+	}
+	return cost
+}
+
+// usagePeriod is the part of a line item that is written at one point in time
+type usagePeriod struct {
+	date  time.Time
+	ratio float64
+}
+
+// dailyPeriods returns the part of a line item that belongs to the day of the date,
+// or no period if the line item doesn't belong to the day
+func dailyPeriods(lineItemType string, start time.Time, stop time.Time, date time.Time) []usagePeriod {
+	ratio := lineItemRatio(lineItemType, start, stop, date)
+	if ratio == 0 {
+		return nil
+	}
+	return []usagePeriod{{date: wholeHour(date), ratio: ratio}}
+}
+
+// wholeHour returns the first whole UTC hour at or after the time. Points are written by the hour,
+// so the start of a day in a time zone with a half hour offset, e.g. Asia/Kolkata, would otherwise
+// be written on the day before.
+func wholeHour(date time.Time) time.Time {
+	hour := date.Truncate(time.Hour)
+	if hour.Equal(date) {
+		return date
+	}
+	return hour.Add(time.Hour)
+}
+
+// hourlyPeriods splits the part of a line item that belongs to the day of the date into the hours it was used.
+// One-time fees are charged in full in the hour they start. Hours without usage are left out.
+func hourlyPeriods(lineItemType string, start time.Time, stop time.Time, date time.Time) []usagePeriod {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	next := day.AddDate(0, 0, 1)
+	duration := float64(stop.Unix() - start.Unix())
+
+	var periods []usagePeriod
+	for hour := day; hour.Before(next); hour = hour.Add(time.Hour) {
+		end := hour.Add(time.Hour)
+		if isOneTime(lineItemType) {
+			if !start.Before(hour) && start.Before(end) {
+				periods = append(periods, usagePeriod{date: hour, ratio: 1})
+			}
+			continue
+		}
+		if used := overlap(start.Unix(), stop.Unix(), hour.Unix(), end.Unix()); used > 0 {
+			periods = append(periods, usagePeriod{date: hour, ratio: used / duration})
+		}
+	}
+	return periods
+}
@@ -0,0 +1,173 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var lineItemsReport = `identity/LineItemId,lineItem/LineItemType,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/CurrencyCode,lineItem/BlendedCost
+usage,Usage,2018-08-01T00:00:00Z,2018-08-03T00:00:00Z,AmazonEC2,USD,10
+upfront,Fee,2018-08-02T10:00:00Z,2018-08-02T10:00:00Z,AmazonEC2,USD,300
+credit,Credit,2018-08-01T00:00:00Z,2018-08-03T00:00:00Z,AmazonEC2,USD,4
+refund,Refund,2018-08-01T00:00:00Z,2018-08-03T00:00:00Z,AmazonEC2,USD,-2
+tax,Tax,2018-08-01T00:00:00Z,2018-08-03T00:00:00Z,AmazonEC2,USD,1
+`
+
+func TestLineItemTypes(t *testing.T) {
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{Strict: true, GroupBy: []string{"service"}}})
+
+	// The fee is only on the day it is charged
+	cases := []struct {
+		date time.Time
+		want map[string]float64
+	}{
+		{time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC), map[string]float64{"Usage": 5, "Credit": -2, "Refund": -1, "Tax": 0.5}},
+		{time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC), map[string]float64{"Usage": 5, "Fee": 300, "Credit": -2, "Refund": -1, "Tax": 0.5}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.date.Format("2006-01-02"), func(t *testing.T) {
+			data, err := client.ReadReport("report.csv", strings.NewReader(lineItemsReport), c.date)
+			if err != nil {
+				t.Fatalf("Caught error: %s", err)
+			}
+
+			// The line item types are kept apart even when grouping by service
+			if len(data) != len(c.want) {
+				t.Errorf("Expected one group per line item type but got %v", data)
+			}
+			actual := make(map[string]float64)
+			for _, row := range data {
+				actual[row.Labels["line_item_type"]] += row.Cost
+			}
+			for lineItemType, want := range c.want {
+				if !approxEqual(actual[lineItemType], want) {
+					t.Errorf("Expected cost %v for %s but got %v", want, lineItemType, actual[lineItemType])
+				}
+			}
+		})
+	}
+}
+
+func TestReadReportOtherDays(t *testing.T) {
+	report := `identity/LineItemId,lineItem/LineItemType,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/CurrencyCode,lineItem/BlendedCost
+s3-1,Usage,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonS3,USD,1
+s3-9,Usage,2018-08-09T00:00:00Z,2018-08-10T00:00:00Z,AmazonS3,USD,2
+ec2-9,Usage,2018-08-09T00:00:00Z,2018-08-10T00:00:00Z,AmazonEC2,USD,3
+`
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{Strict: true, GroupBy: []string{"service"}}})
+	data, err := client.ReadReport("report.csv", strings.NewReader(report), time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	// Line items of other days are left out instead of being written with zero cost
+	if len(data) != 1 || data[0].Labels["service"] != "AmazonS3" {
+		t.Fatalf("Expected only AmazonS3 but got %v", data)
+	}
+	if data[0].Fields["line_items"] != 1 || !approxEqual(data[0].Cost, 1) {
+		t.Errorf("Expected one line item with cost 1 but got %v", data[0])
+	}
+}
+
+func TestDailyPeriods(t *testing.T) {
+	start := time.Date(2018, time.August, 9, 0, 0, 0, 0, time.UTC)
+	stop := start.AddDate(0, 0, 1)
+	if periods := dailyPeriods("Usage", start, stop, time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)); len(periods) != 0 {
+		t.Errorf("Expected no periods on another day but got %v", periods)
+	}
+	if periods := dailyPeriods("Usage", start, stop, start); len(periods) != 1 || periods[0].ratio != 1 {
+		t.Errorf("Expected the whole line item on the day but got %v", periods)
+	}
+}
+
+func TestLineItemRatio(t *testing.T) {
+	start := time.Date(2018, time.August, 2, 22, 0, 0, 0, time.UTC)
+	stop := start.Add(4 * time.Hour)
+	cases := []struct {
+		lineItemType string
+		date         time.Time
+		want         float64
+	}{
+		{"Usage", time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC), 0.5},
+		{"Usage", time.Date(2018, time.August, 3, 0, 0, 0, 0, time.UTC), 0.5},
+		{lineItemFee, time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC), 1},
+		{lineItemFee, time.Date(2018, time.August, 3, 0, 0, 0, 0, time.UTC), 0},
+		{lineItemSavingsPlanUpfrontFee, time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC), 1},
+		// The day of the fee is taken in the time zone of the date
+		{lineItemFee, time.Date(2018, time.August, 3, 0, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), 1},
+	}
+
+	for _, c := range cases {
+		if actual := lineItemRatio(c.lineItemType, start, stop, c.date); actual != c.want {
+			t.Errorf("Expected ratio %v for %s on %v but got %v", c.want, c.lineItemType, c.date, actual)
+		}
+	}
+}
+
+func TestHourlyPeriods(t *testing.T) {
+	date := time.Date(2018, time.August, 2, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Hourly line item", func(t *testing.T) {
+		start := time.Date(2018, time.August, 2, 13, 0, 0, 0, time.UTC)
+		periods := hourlyPeriods("Usage", start, start.Add(time.Hour), date)
+		if len(periods) != 1 || !periods[0].date.Equal(start) || periods[0].ratio != 1 {
+			t.Errorf("Expected the whole line item at %v but got %v", start, periods)
+		}
+	})
+
+	t.Run("Line item over two days", func(t *testing.T) {
+		start := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+		periods := hourlyPeriods("Usage", start, start.AddDate(0, 0, 2), date)
+		if len(periods) != 24 {
+			t.Fatalf("Expected 24 hours but got %d", len(periods))
+		}
+		if !periods[0].date.Equal(date) || !approxEqual(periods[0].ratio, 1.0/48) {
+			t.Errorf("Expected 1/48 of the line item at %v but got %v", date, periods[0])
+		}
+	})
+
+	t.Run("Fee", func(t *testing.T) {
+		start := time.Date(2018, time.August, 2, 10, 30, 0, 0, time.UTC)
+		periods := hourlyPeriods(lineItemFee, start, start, date)
+		expected := time.Date(2018, time.August, 2, 10, 0, 0, 0, time.UTC)
+		if len(periods) != 1 || !periods[0].date.Equal(expected) || periods[0].ratio != 1 {
+			t.Errorf("Expected the whole fee at %v but got %v", expected, periods)
+		}
+	})
+
+	t.Run("Other day", func(t *testing.T) {
+		start := time.Date(2018, time.August, 3, 10, 0, 0, 0, time.UTC)
+		if periods := hourlyPeriods("Usage", start, start.Add(time.Hour), date); len(periods) != 0 {
+			t.Errorf("Expected no hours but got %v", periods)
+		}
+	})
+}
+
+func TestReadReportHourly(t *testing.T) {
+	date := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	client := NewFileClient(FileConfig{IngestionConfig: IngestionConfig{Strict: true, GroupBy: []string{"service"}, Hourly: true}})
+
+	data, err := client.ReadReport("report.csv", strings.NewReader(lineItemsReport), date)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	// Usage, credit, refund and tax for every hour of the day
+	if len(data) != 4*24 {
+		t.Fatalf("Expected %d points but got %d", 4*24, len(data))
+	}
+	total := 0.0
+	for _, row := range data {
+		if row.Labels["line_item_type"] == "Usage" {
+			total += row.Cost
+			if row.Date.Before(date) || !row.Date.Before(date.AddDate(0, 0, 1)) || row.Date.Minute() != 0 {
+				t.Errorf("Expected a point at an hour of %v but got %v", date, row.Date)
+			}
+		}
+	}
+	if !approxEqual(total, 5) {
+		t.Errorf("Expected usage cost 5 for the day but got %v", total)
+	}
+}
@@ -0,0 +1,218 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// manifest describes the latest version of a report for one billing period.
+// See https://docs.aws.amazon.com/cur/latest/userguide/understanding-report-versions.html
+// Manifests of CUR 2.0 exports list the parts as S3 URIs in dataFiles instead, which are read into ReportKeys.
+type manifest struct {
+	AssemblyID  string           `json:"assemblyId"`
+	ReportName  string           `json:"reportName"`
+	Bucket      string           `json:"bucket"`
+	Compression string           `json:"compression"`
+	ContentType string           `json:"contentType"`
+	Columns     []manifestColumn `json:"columns"`
+	ReportKeys  []string         `json:"reportKeys"`
+	DataFiles   []string         `json:"dataFiles"`
+}
+
+// manifestColumn is a column of the report, e.g. category lineItem and name UsageStartDate
+type manifestColumn struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+}
+
+// reportFormat is the file format of the report parts
+type reportFormat int
+
+const (
+	formatCSV reportFormat = iota
+	formatParquet
+)
+
+// format returns the format of a report part, as given by the manifest or else by the suffix of the key
+func (m *manifest) format(key string) reportFormat {
+	if strings.EqualFold(m.ContentType, "Parquet") || strings.EqualFold(m.Compression, "Parquet") ||
+		strings.HasSuffix(strings.ToLower(key), ".parquet") {
+		return formatParquet
+	}
+	return formatCSV
+}
+
+// billingPeriod returns the name of the billing period containing the timestamp, e.g. 20180801-20180901.
+// Billing periods are calendar months in UTC.
+func billingPeriod(timestamp time.Time) string {
+	form := "20060102"
+	timestamp = timestamp.UTC()
+	start := time.Date(timestamp.Year(), timestamp.Month(), 1, 0, 0, 0, 0, time.UTC)
+	stop := start.AddDate(0, 1, 0)
+	return start.Format(form) + "-" + stop.Format(form)
+}
+
+// billingPeriodsOfDay returns a timestamp in every billing period that the day overlaps.
+// A day in another time zone than UTC can overlap two billing periods.
+func billingPeriodsOfDay(day time.Time) []time.Time {
+	first := day.UTC()
+	last := day.AddDate(0, 0, 1).Add(-time.Nanosecond).UTC()
+	if first.Year() == last.Year() && first.Month() == last.Month() {
+		return []time.Time{first}
+	}
+	return []time.Time{first, last}
+}
+
+// billingPeriodPath returns the path that all files of the billing period containing the timestamp are stored under
+func billingPeriodPath(prefix, name string, timestamp time.Time) string {
+	return reportPath(prefix, name) + billingPeriod(timestamp) + "/"
+}
+
+// manifestKey returns the key of the manifest for the billing period containing the timestamp
+func manifestKey(prefix, name string, timestamp time.Time) string {
+	return billingPeriodPath(prefix, name, timestamp) + name + "-Manifest.json"
+}
+
+// exportMetadataPath returns the path that the manifests of a CUR 2.0 export are stored under
+// for the billing period containing the timestamp, e.g. prefix/name/metadata/BILLING_PERIOD=2018-08/
+func exportMetadataPath(prefix, name string, timestamp time.Time) string {
+	return reportPath(prefix, name) + "metadata/BILLING_PERIOD=" + timestamp.UTC().Format("2006-01") + "/"
+}
+
+// athenaPartitionPath returns the path that the Parquet files of a report integrated with Athena are stored under
+// for the billing period containing the timestamp, e.g. prefix/name/name/year=2018/month=8/
+func athenaPartitionPath(prefix, name string, timestamp time.Time) string {
+	timestamp = timestamp.UTC()
+	return fmt.Sprintf("%s%s/year=%d/month=%d/", reportPath(prefix, name), name, timestamp.Year(), int(timestamp.Month()))
+}
+
+// getManifest reads the manifest of the billing period containing the timestamp.
+// If the manifest of the billing period is missing, the newest manifest of a report version is used,
+// or else the newest manifest of a CUR 2.0 export. Without any manifest, the Parquet files of a report
+// integrated with Athena are read.
+func (client *Client) getManifest(ctx context.Context, timestamp time.Time) (*manifest, error) {
+	key := manifestKey(client.reportPrefix, client.reportName, timestamp)
+	resp, err := client.service.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(client.bucket),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		key, err = client.findLatestManifestKey(ctx, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return client.getAthenaManifest(ctx, timestamp)
+		}
+		resp, err = client.service.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(client.bucket),
+			Key:    aws.String(key),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get manifest s3://%s/%s: %v", client.bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	m, err := parseManifest(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest s3://%s/%s: %v", client.bucket, key, err)
+	}
+	return m, nil
+}
+
+// findLatestManifestKey returns the key of the newest manifest of the billing period, of a report version
+// or else of a CUR 2.0 export. Returns an empty key if there is none.
+func (client *Client) findLatestManifestKey(ctx context.Context, timestamp time.Time) (string, error) {
+	prefixes := []string{
+		billingPeriodPath(client.reportPrefix, client.reportName, timestamp),
+		exportMetadataPath(client.reportPrefix, client.reportName, timestamp),
+	}
+	for _, prefix := range prefixes {
+		var key string
+		var latest time.Time
+		err := client.listObjects(ctx, prefix, func(object *s3.Object) {
+			modified := aws.TimeValue(object.LastModified)
+			if strings.HasSuffix(aws.StringValue(object.Key), "-Manifest.json") && (key == "" || modified.After(latest)) {
+				key = aws.StringValue(object.Key)
+				latest = modified
+			}
+		})
+		if err != nil || key != "" {
+			return key, err
+		}
+	}
+	return "", nil
+}
+
+// getAthenaManifest returns a manifest of the Parquet files of the billing period of a report integrated with Athena.
+// The manifest has no columns since they are only listed in the files.
+func (client *Client) getAthenaManifest(ctx context.Context, timestamp time.Time) (*manifest, error) {
+	prefix := athenaPartitionPath(client.reportPrefix, client.reportName, timestamp)
+	m := &manifest{ReportName: client.reportName, Bucket: client.bucket, ContentType: "Parquet"}
+	err := client.listObjects(ctx, prefix, func(object *s3.Object) {
+		if key := aws.StringValue(object.Key); strings.HasSuffix(key, ".parquet") {
+			m.ReportKeys = append(m.ReportKeys, key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(m.ReportKeys) == 0 {
+		return nil, fmt.Errorf("there is no manifest for the billing period in s3://%s/%s or s3://%s/%s and no Parquet files in s3://%s/%s",
+			client.bucket, billingPeriodPath(client.reportPrefix, client.reportName, timestamp),
+			client.bucket, exportMetadataPath(client.reportPrefix, client.reportName, timestamp),
+			client.bucket, prefix)
+	}
+	return m, nil
+}
+
+// listObjects pages through all objects with the prefix
+func (client *Client) listObjects(ctx context.Context, prefix string, fn func(*s3.Object)) error {
+	params := &s3.ListObjectsV2Input{
+		Bucket: aws.String(client.bucket),
+		Prefix: aws.String(prefix),
+	}
+	err := client.service.ListObjectsV2PagesWithContext(ctx, params, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			fn(object)
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list s3://%s/%s: %v", client.bucket, prefix, err)
+	}
+	return nil
+}
+
+// parseManifest reads a manifest of a report or of a CUR 2.0 export
+func parseManifest(r io.Reader) (*manifest, error) {
+	var m manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	// The data files are S3 URIs, e.g. s3://billing/prefix/name/data/BILLING_PERIOD=2018-08/name-00001.snappy.parquet
+	for _, uri := range m.DataFiles {
+		key := strings.TrimPrefix(uri, "s3://")
+		if i := strings.Index(key, "/"); i >= 0 && key != uri {
+			key = key[i+1:]
+		}
+		m.ReportKeys = append(m.ReportKeys, key)
+	}
+	if len(m.ReportKeys) == 0 {
+		return nil, errors.New("no report keys are listed")
+	}
+	if len(m.Columns) == 0 {
+		return nil, errors.New("no columns are listed")
+	}
+	return &m, nil
+}
@@ -0,0 +1,331 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var manifestJSON = `{
+  "assemblyId": "a1b2c3",
+  "account": "123456789012",
+  "columns": [
+    {"category": "identity", "name": "LineItemId"},
+    {"category": "lineItem", "name": "UsageStartDate"}
+  ],
+  "charset": "UTF-8",
+  "compression": "GZIP",
+  "contentType": "text/csv",
+  "reportId": "abcdef",
+  "reportName": "test-usage-report",
+  "billingPeriod": {"start": "20180801T000000.000Z", "end": "20180901T000000.000Z"},
+  "bucket": "billing",
+  "reportKeys": [
+    "daily-report/test-usage-report/20180801-20180901/a1b2c3/test-usage-report-1.csv.gz",
+    "daily-report/test-usage-report/20180801-20180901/a1b2c3/test-usage-report-2.csv.gz"
+  ],
+  "additionalArtifactKeys": []
+}`
+
+// A manifest of a report integrated with Athena, with the Parquet files in partitions of the billing period
+var parquetManifestJSON = `{
+  "assemblyId": "a1b2c3",
+  "account": "123456789012",
+  "columns": [
+    {"category": "identity", "name": "LineItemId", "type": "String"},
+    {"category": "lineItem", "name": "UsageStartDate", "type": "DateTime"},
+    {"category": "lineItem", "name": "UsageEndDate", "type": "DateTime"},
+    {"category": "lineItem", "name": "ProductCode", "type": "String"},
+    {"category": "lineItem", "name": "CurrencyCode", "type": "String"},
+    {"category": "lineItem", "name": "BlendedCost", "type": "BigDecimal"}
+  ],
+  "charset": "UTF-8",
+  "compression": "Parquet",
+  "contentType": "Parquet",
+  "reportId": "abcdef",
+  "reportName": "test-usage-report",
+  "billingPeriod": {"start": "20180801T000000.000Z", "end": "20180901T000000.000Z"},
+  "bucket": "billing",
+  "reportKeys": [
+    "daily-report/test-usage-report/test-usage-report/year=2018/month=8/test-usage-report-00001.snappy.parquet"
+  ],
+  "additionalArtifactKeys": []
+}`
+
+// A manifest of a CUR 2.0 export, whose columns have no categories
+var exportManifestJSON = `{
+  "executionId": "0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0",
+  "exportArn": "arn:aws:bcm-data-exports:us-east-1:123456789012:export/test-usage-report-0a1b2c3d",
+  "exportName": "test-usage-report",
+  "billingPeriod": {"start": "2018-08-01T00:00:00.000Z", "end": "2018-09-01T00:00:00.000Z"},
+  "bucket": "billing",
+  "deliveryTime": "2018-08-11T04:12:35.000Z",
+  "columns": [
+    {"name": "bill_payer_account_id", "type": "string"},
+    {"name": "identity_line_item_id", "type": "string"},
+    {"name": "line_item_blended_cost", "type": "double"},
+    {"name": "line_item_currency_code", "type": "string"},
+    {"name": "line_item_product_code", "type": "string"},
+    {"name": "line_item_usage_end_date", "type": "timestamp"},
+    {"name": "line_item_usage_start_date", "type": "timestamp"},
+    {"name": "resource_tags", "type": "map<string,string>"}
+  ],
+  "dataFiles": [
+    "s3://billing/daily-report/test-usage-report/data/BILLING_PERIOD=2018-08/test-usage-report-00001.snappy.parquet",
+    "s3://billing/daily-report/test-usage-report/data/BILLING_PERIOD=2018-08/test-usage-report-00002.snappy.parquet"
+  ]
+}`
+
+func TestParseManifest(t *testing.T) {
+	m, err := parseManifest(strings.NewReader(manifestJSON))
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	if m.AssemblyID != "a1b2c3" || m.Compression != "GZIP" {
+		t.Errorf("Manifest not parsed correctly: %+v", m)
+	}
+	if len(m.ReportKeys) != 2 {
+		t.Errorf("Expected 2 report keys, got %d", len(m.ReportKeys))
+	}
+	if len(m.Columns) != 2 || m.Columns[1] != (manifestColumn{Category: "lineItem", Name: "UsageStartDate"}) {
+		t.Errorf("Columns not parsed correctly: %v", m.Columns)
+	}
+}
+
+func TestParseExportManifest(t *testing.T) {
+	m, err := parseManifest(strings.NewReader(exportManifestJSON))
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	expected := "daily-report/test-usage-report/data/BILLING_PERIOD=2018-08/test-usage-report-00002.snappy.parquet"
+	if len(m.ReportKeys) != 2 || m.ReportKeys[1] != expected {
+		t.Errorf("Expected the keys of the data files but got %v", m.ReportKeys)
+	}
+	if m.format(m.ReportKeys[0]) != formatParquet {
+		t.Errorf("Expected Parquet data files")
+	}
+
+	// The columns are found by the names of the report columns
+	query, err := newReportQuery(m.Columns, blendedColumns, []string{columnPayerAccountID, columnUsageAmount})
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	want := `SELECT s."identity_line_item_id", s."line_item_usage_start_date", s."line_item_usage_end_date", s."line_item_product_code", s."line_item_currency_code", s."line_item_blended_cost", s."bill_payer_account_id" FROM S3Object s`
+	if actual := query.sql(formatParquet); actual != want {
+		t.Errorf("Expected query %s but got %s", want, actual)
+	}
+}
+
+func TestParseManifestInvalid(t *testing.T) {
+	cases := []string{
+		"not json",
+		`{"assemblyId": "a1b2c3", "reportKeys": []}`,
+		`{"assemblyId": "a1b2c3", "reportKeys": ["report-1.csv.gz"]}`,
+		`{"exportName": "test-usage-report", "dataFiles": [], "columns": [{"name": "identity_line_item_id"}]}`,
+	}
+
+	for _, c := range cases {
+		if _, err := parseManifest(strings.NewReader(c)); err == nil {
+			t.Errorf("Expected error for %q but got none!", c)
+		}
+	}
+}
+
+func TestManifestKey(t *testing.T) {
+	timestamp := time.Date(2018, time.December, 10, 12, 0, 0, 0, time.UTC)
+	expected := "daily-report/test-usage-report/20181201-20190101/test-usage-report-Manifest.json"
+	actual := manifestKey("daily-report", "test-usage-report", timestamp)
+	if actual != expected {
+		t.Errorf("Expected key %s but got %s", expected, actual)
+	}
+}
+
+func TestBillingPeriodsOfDay(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	cases := []struct {
+		name string
+		day  time.Time
+		want []string
+	}{
+		{"Day in UTC", time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC), []string{"20180801-20180901"}},
+		{"Last day of the year in UTC", time.Date(2018, time.December, 31, 0, 0, 0, 0, time.UTC), []string{"20181201-20190101"}},
+		{"Middle of the month", time.Date(2018, time.August, 10, 0, 0, 0, 0, stockholm), []string{"20180801-20180901"}},
+		{"First day of the month east of UTC", time.Date(2018, time.August, 1, 0, 0, 0, 0, stockholm), []string{"20180701-20180801", "20180801-20180901"}},
+		{"Last day of the month west of UTC", time.Date(2018, time.December, 31, 0, 0, 0, 0, newYork), []string{"20181201-20190101", "20190101-20190201"}},
+	}
+
+	for _, c := range cases {
+		periods := billingPeriodsOfDay(c.day)
+		actual := make([]string, len(periods))
+		for i, period := range periods {
+			actual[i] = billingPeriod(period)
+		}
+		if strings.Join(actual, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: expected billing periods %v but got %v", c.name, c.want, actual)
+		}
+	}
+}
+
+func TestGetManifest(t *testing.T) {
+	timestamp := time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC)
+	period := "daily-report/test-usage-report/20180801-20180901/"
+	newerManifest := strings.Replace(manifestJSON, "a1b2c3", "d4e5f6", -1)
+	newClient := func(service *fakeS3) Client {
+		return Client{bucket: "billing", reportPrefix: "daily-report", reportName: "test-usage-report", service: service}
+	}
+
+	t.Run("Manifest of the billing period", func(t *testing.T) {
+		client := newClient(&fakeS3{pageSize: 2, objects: map[string]string{
+			period + "test-usage-report-Manifest.json": manifestJSON,
+		}})
+		m, err := client.getManifest(context.Background(), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		if m.AssemblyID != "a1b2c3" {
+			t.Errorf("Expected assembly a1b2c3, got %s", m.AssemblyID)
+		}
+	})
+
+	t.Run("Newest manifest of a report version", func(t *testing.T) {
+		client := newClient(&fakeS3{
+			pageSize: 2,
+			objects: map[string]string{
+				period + "a1b2c3/test-usage-report-1.csv.gz":        "",
+				period + "a1b2c3/test-usage-report-Manifest.json":   manifestJSON,
+				period + "d4e5f6/test-usage-report-1.csv.gz":        "",
+				period + "d4e5f6/test-usage-report-Manifest.json":   newerManifest,
+				"daily-report/test-usage-report/20180701-20180801/": "",
+			},
+			modified: map[string]time.Time{
+				period + "a1b2c3/test-usage-report-Manifest.json": timestamp,
+				period + "d4e5f6/test-usage-report-Manifest.json": timestamp.AddDate(0, 0, 1),
+			},
+		})
+		m, err := client.getManifest(context.Background(), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		if m.AssemblyID != "d4e5f6" {
+			t.Errorf("Expected assembly d4e5f6, got %s", m.AssemblyID)
+		}
+	})
+
+	t.Run("Manifest of a report integrated with Athena", func(t *testing.T) {
+		client := newClient(&fakeS3{pageSize: 2, objects: map[string]string{
+			period + "test-usage-report-Manifest.json": parquetManifestJSON,
+			"daily-report/test-usage-report/test-usage-report/year=2018/month=8/test-usage-report-00001.snappy.parquet": "",
+		}})
+		m, err := client.getManifest(context.Background(), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		if len(m.ReportKeys) != 1 || m.format(m.ReportKeys[0]) != formatParquet || len(m.Columns) != 6 {
+			t.Errorf("Expected the Parquet file with 6 columns but got %+v", m)
+		}
+	})
+
+	t.Run("Newest manifest of a CUR 2.0 export", func(t *testing.T) {
+		metadata := "daily-report/test-usage-report/metadata/BILLING_PERIOD=2018-08/"
+		olderManifest := strings.Replace(exportManifestJSON, "00002", "00003", -1)
+		client := newClient(&fakeS3{
+			pageSize: 2,
+			objects: map[string]string{
+				metadata + "test-usage-report-Manifest.json":                                                        exportManifestJSON,
+				metadata + "0a1b2c3d/test-usage-report-Manifest.json":                                               olderManifest,
+				"daily-report/test-usage-report/metadata/BILLING_PERIOD=2018-07/test-usage-report-Manifest.json":    olderManifest,
+				"daily-report/test-usage-report/data/BILLING_PERIOD=2018-08/test-usage-report-00001.snappy.parquet": "",
+			},
+			modified: map[string]time.Time{
+				metadata + "test-usage-report-Manifest.json":          timestamp.AddDate(0, 0, 1),
+				metadata + "0a1b2c3d/test-usage-report-Manifest.json": timestamp,
+			},
+		})
+		m, err := client.getManifest(context.Background(), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		if len(m.ReportKeys) != 2 || !strings.HasSuffix(m.ReportKeys[1], "test-usage-report-00002.snappy.parquet") {
+			t.Errorf("Expected the data files of the newest manifest but got %v", m.ReportKeys)
+		}
+	})
+
+	t.Run("Parquet files of a report integrated with Athena", func(t *testing.T) {
+		partition := "daily-report/test-usage-report/test-usage-report/year=2018/month=8/"
+		client := newClient(&fakeS3{pageSize: 2, objects: map[string]string{
+			partition + "test-usage-report-00001.snappy.parquet":                                                             "",
+			partition + "test-usage-report-00002.snappy.parquet":                                                             "",
+			"daily-report/test-usage-report/test-usage-report/year=2018/month=7/test-usage-report-00001.snappy.parquet":      "",
+			"daily-report/test-usage-report/test-usage-report/cost_and_usage_data_status/cost_and_usage_data_status.parquet": "",
+			"daily-report/test-usage-report/crawler-cfn.yml":                                                                 "",
+		}})
+		m, err := client.getManifest(context.Background(), timestamp)
+		if err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+		expected := []string{partition + "test-usage-report-00001.snappy.parquet", partition + "test-usage-report-00002.snappy.parquet"}
+		if strings.Join(m.ReportKeys, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected keys %v but got %v", expected, m.ReportKeys)
+		}
+		if m.format(m.ReportKeys[0]) != formatParquet || len(m.Columns) != 0 {
+			t.Errorf("Expected Parquet files without columns but got %+v", m)
+		}
+	})
+
+	t.Run("No manifest", func(t *testing.T) {
+		client := newClient(&fakeS3{pageSize: 2, objects: map[string]string{
+			"daily-report/test-usage-report/20180701-20180801/test-usage-report-Manifest.json": manifestJSON,
+		}})
+		if _, err := client.getManifest(context.Background(), timestamp); err == nil {
+			t.Errorf("Expected error but got none!")
+		}
+	})
+
+	t.Run("Error when listing", func(t *testing.T) {
+		client := newClient(&fakeS3{pageSize: 2, listErr: errors.New("error")})
+		if _, err := client.getManifest(context.Background(), timestamp); err == nil {
+			t.Errorf("Expected error but got none!")
+		}
+	})
+}
+
+func TestReportLayoutPaths(t *testing.T) {
+	timestamp := time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC)
+	if actual := exportMetadataPath("daily-report", "test-usage-report", timestamp); actual != "daily-report/test-usage-report/metadata/BILLING_PERIOD=2018-08/" {
+		t.Errorf("Unexpected metadata path %s", actual)
+	}
+	if actual := athenaPartitionPath("daily-report", "test-usage-report", timestamp); actual != "daily-report/test-usage-report/test-usage-report/year=2018/month=8/" {
+		t.Errorf("Unexpected partition path %s", actual)
+	}
+}
+
+func TestManifestFormat(t *testing.T) {
+	cases := []struct {
+		manifest manifest
+		key      string
+		want     reportFormat
+	}{
+		{manifest{Compression: "GZIP", ContentType: "text/csv"}, "report-1.csv.gz", formatCSV},
+		{manifest{Compression: "Parquet", ContentType: "Parquet"}, "report-1.snappy.parquet", formatParquet},
+		{manifest{}, "report-1.snappy.parquet", formatParquet},
+		{manifest{}, "report-1.csv.gz", formatCSV},
+	}
+
+	for _, c := range cases {
+		if actual := c.manifest.format(c.key); actual != c.want {
+			t.Errorf("Expected format %d for %s with %+v but got %d", c.want, c.key, c.manifest, actual)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package aws
+
+import (
+	"fmt"
+)
+
+// Cost metrics that can be read from the report
+const (
+	MetricUnblended      = "unblended"
+	MetricBlended        = "blended"
+	MetricNetUnblended   = "net_unblended"
+	MetricAmortized      = "amortized"
+	MetricPublicOnDemand = "public_on_demand"
+)
+
+// costMetric describes how one kind of cost is calculated from the columns of a line item
+type costMetric struct {
+	name     string
+	required []string
+	optional []string
+	cost     func(p *rowParser) float64
+}
+
+// field returns the name of the field that the metric is written to, e.g. amortized_cost
+func (metric costMetric) field() string {
+	return metric.name + "_cost"
+}
+
+// columnMetric is a metric that is read directly from a column
+func columnMetric(name, column string) costMetric {
+	return costMetric{
+		name:     name,
+		required: []string{column},
+		cost: func(p *rowParser) float64 {
+			return p.float(column)
+		},
+	}
+}
+
+var costMetrics = map[string]costMetric{
+	MetricUnblended:      columnMetric(MetricUnblended, columnUnblendedCost),
+	MetricBlended:        columnMetric(MetricBlended, columnBlendedCost),
+	MetricNetUnblended:   columnMetric(MetricNetUnblended, columnNetUnblendedCost),
+	MetricPublicOnDemand: columnMetric(MetricPublicOnDemand, columnPublicOnDemand),
+	MetricAmortized: {
+		name:     MetricAmortized,
+		required: []string{columnLineItemType, columnUnblendedCost},
+		// The reservation and savings plan columns are only in the report if they have been used
+		optional: []string{
+			columnReservationARN,
+			columnReservationEffectiveCost,
+			columnReservationUnusedUpfront,
+			columnReservationUnusedRecurring,
+			columnSavingsPlanEffectiveCost,
+			columnSavingsPlanTotalCommitment,
+			columnSavingsPlanUsedCommitment,
+		},
+		cost: amortizedCost,
+	},
+}
+
+// amortizedCost spreads the cost of reservations and savings plans over the usage they cover.
+// See https://docs.aws.amazon.com/cur/latest/userguide/amortized-costs.html
+func amortizedCost(p *rowParser) float64 {
+	switch p.string(columnLineItemType) {
+	case "SavingsPlanCoveredUsage":
+		return p.optionalFloat(columnSavingsPlanEffectiveCost)
+	case "SavingsPlanRecurringFee":
+		// Only the unused part of the commitment, the used part is in the covered usage
+		return p.optionalFloat(columnSavingsPlanTotalCommitment) - p.optionalFloat(columnSavingsPlanUsedCommitment)
+	case "SavingsPlanNegation", "SavingsPlanUpfrontFee":
+		return 0
+	case "DiscountedUsage":
+		return p.optionalFloat(columnReservationEffectiveCost)
+	case "RIFee":
+		return p.optionalFloat(columnReservationUnusedUpfront) + p.optionalFloat(columnReservationUnusedRecurring)
+	case "Fee":
+		// The upfront fee of a reservation is amortized over the usage it covers
+		if p.string(columnReservationARN) != "" {
+			return 0
+		}
+	}
+	return p.float(columnUnblendedCost)
+}
+
+// newCostMetrics returns the metrics with the names, or blended cost if there are none
+func newCostMetrics(names []string) ([]costMetric, error) {
+	if len(names) == 0 {
+		names = []string{MetricBlended}
+	}
+
+	metrics := make([]costMetric, 0, len(names))
+	for _, name := range names {
+		metric, ok := costMetrics[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cost metric %q", name)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
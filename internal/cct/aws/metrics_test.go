@@ -0,0 +1,100 @@
+package aws
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+)
+
+var metricsReport = `identity/LineItemId,lineItem/UsageStartDate,lineItem/UsageEndDate,lineItem/ProductCode,lineItem/CurrencyCode,lineItem/LineItemType,lineItem/UnblendedCost,lineItem/BlendedCost,lineItem/NetUnblendedCost,pricing/publicOnDemandCost,reservation/ReservationARN,reservation/EffectiveCost,reservation/UnusedAmortizedUpfrontFeeForBillingPeriod,reservation/UnusedRecurringFee,savingsPlan/SavingsPlanEffectiveCost,savingsPlan/TotalCommitmentToDate,savingsPlan/UsedCommitment
+usage,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonS3,USD,Usage,1,1.1,0.9,1.2,,,,,,,
+ri-usage,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonEC2,USD,DiscountedUsage,0,0.5,0,3,arn:ri,0.8,,,,,
+ri-fee,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonEC2,USD,RIFee,0.6,0.6,0.6,0,arn:ri,,0.1,0.2,,,
+ri-upfront,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonEC2,USD,Fee,100,100,100,0,arn:ri,,,,,,
+sp-usage,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonEC2,USD,SavingsPlanCoveredUsage,2,2,2,2,,,,,1.5,,
+sp-negation,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,AmazonEC2,USD,SavingsPlanNegation,-2,-2,-2,0,,,,,,,
+sp-fee,2018-08-01T00:00:00Z,2018-08-02T00:00:00Z,ComputeSavingsPlans,USD,SavingsPlanRecurringFee,2,2,2,0,,,,,,2,1.5
+`
+
+// readLineItems reads the line items of the metrics report without grouping them
+func readLineItems(t *testing.T, metrics []string) []dbclient.UsageData {
+	timestamp := time.Date(2018, time.August, 1, 0, 0, 0, 0, time.UTC)
+	in := newIngestion(IngestionConfig{CostMetrics: metrics})
+	report := reportRows{ingestion: &in}
+	if err := report.read("report.csv", strings.NewReader(metricsReport), timestamp); err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	if len(report.errs) > 0 || len(report.data) != 7 {
+		t.Fatalf("Expected 7 line items without errors but got %v and %v", report.data, report.errs)
+	}
+	return report.data
+}
+
+func TestCostMetrics(t *testing.T) {
+	cases := []struct {
+		metric string
+		want   map[string]float64
+	}{
+		{MetricBlended, map[string]float64{"usage": 1.1, "ri-usage": 0.5, "ri-upfront": 100, "sp-usage": 2, "sp-negation": -2}},
+		{MetricUnblended, map[string]float64{"usage": 1, "ri-usage": 0, "ri-fee": 0.6, "sp-fee": 2}},
+		{MetricNetUnblended, map[string]float64{"usage": 0.9, "ri-fee": 0.6}},
+		{MetricPublicOnDemand, map[string]float64{"usage": 1.2, "ri-usage": 3, "sp-negation": 0}},
+		{MetricAmortized, map[string]float64{"usage": 1, "ri-usage": 0.8, "ri-fee": 0.3, "ri-upfront": 0, "sp-usage": 1.5, "sp-negation": 0, "sp-fee": 0.5}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.metric, func(t *testing.T) {
+			for _, row := range readLineItems(t, []string{c.metric}) {
+				id := row.Attributes["line_item_id"]
+				if want, ok := c.want[id]; ok && !approxEqual(row.Cost, want) {
+					t.Errorf("Expected cost %v for %s but got %v", want, id, row.Cost)
+				}
+				if len(row.Fields) != 1 {
+					t.Errorf("Expected only line_items as field with one metric but got %v", row.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestSeveralCostMetrics(t *testing.T) {
+	data := readLineItems(t, []string{MetricAmortized, MetricUnblended})
+	for _, row := range data {
+		if row.Attributes["line_item_id"] != "ri-usage" {
+			continue
+		}
+		if !approxEqual(row.Cost, 0.8) || !approxEqual(row.Fields["amortized_cost"], 0.8) || row.Fields["unblended_cost"] != 0 {
+			t.Errorf("Expected amortized cost 0.8 and unblended cost 0 but got %v and %v", row.Cost, row.Fields)
+		}
+		if _, ok := row.Fields["unblended_cost"]; !ok {
+			t.Errorf("Expected field unblended_cost but got %v", row.Fields)
+		}
+		return
+	}
+	t.Errorf("Line item ri-usage not found in %v", data)
+}
+
+func TestNewCostMetrics(t *testing.T) {
+	metrics, err := newCostMetrics(nil)
+	if err != nil || len(metrics) != 1 || metrics[0].name != MetricBlended {
+		t.Errorf("Expected blended cost by default but got %v, %v", metrics, err)
+	}
+
+	if _, err := newCostMetrics([]string{MetricAmortized, "list_price"}); err == nil {
+		t.Errorf("Expected error but got none!")
+	}
+}
+
+func TestNetUnblendedColumnMissing(t *testing.T) {
+	// The net cost is only in reports of accounts with discounts
+	in := newIngestion(IngestionConfig{CostMetrics: []string{MetricNetUnblended}})
+	if _, err := in.newQuery(testColumns); err == nil || !strings.Contains(err.Error(), columnNetUnblendedCost) {
+		t.Errorf("Expected error naming %s but got %v", columnNetUnblendedCost, err)
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
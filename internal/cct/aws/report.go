@@ -0,0 +1,54 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	cur "github.com/aws/aws-sdk-go/service/costandusagereportservice"
+)
+
+// The Cost and Usage Report service is only available in this region
+const reportServiceRegion = "us-east-1"
+
+// reportDefinitionsAPI is the part of the Cost and Usage Report service that is used
+type reportDefinitionsAPI interface {
+	DescribeReportDefinitionsPagesWithContext(ctx aws.Context, input *cur.DescribeReportDefinitionsInput, fn func(*cur.DescribeReportDefinitionsOutput, bool) bool, opts ...request.Option) error
+}
+
+// getReportDefinition looks up the definition of the report with the given name
+func getReportDefinition(ctx context.Context, api reportDefinitionsAPI, name string) (*cur.ReportDefinition, error) {
+	var definition *cur.ReportDefinition
+	err := api.DescribeReportDefinitionsPagesWithContext(ctx, &cur.DescribeReportDefinitionsInput{},
+		func(page *cur.DescribeReportDefinitionsOutput, lastPage bool) bool {
+			definition = findReportDefinition(page.ReportDefinitions, name)
+			return definition == nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if definition == nil {
+		return nil, fmt.Errorf("there is no Cost and Usage Report named %q", name)
+	}
+	return definition, nil
+}
+
+func findReportDefinition(definitions []*cur.ReportDefinition, name string) *cur.ReportDefinition {
+	for _, definition := range definitions {
+		if aws.StringValue(definition.ReportName) == name {
+			return definition
+		}
+	}
+	return nil
+}
+
+// configFromDefinition fills in where the report is stored from its definition
+func configFromDefinition(config Config, definition *cur.ReportDefinition) Config {
+	config.Bucket = aws.StringValue(definition.S3Bucket)
+	config.ReportPrefix = aws.StringValue(definition.S3Prefix)
+	if config.Region == "" {
+		config.Region = aws.StringValue(definition.S3Region)
+	}
+	return config
+}
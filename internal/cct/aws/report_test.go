@@ -0,0 +1,117 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	cur "github.com/aws/aws-sdk-go/service/costandusagereportservice"
+)
+
+// fakeReportAPI returns the report definitions in pages
+type fakeReportAPI struct {
+	pages [][]*cur.ReportDefinition
+	err   error
+}
+
+func (api fakeReportAPI) DescribeReportDefinitionsPagesWithContext(ctx aws.Context, input *cur.DescribeReportDefinitionsInput, fn func(*cur.DescribeReportDefinitionsOutput, bool) bool, opts ...request.Option) error {
+	if api.err != nil {
+		return api.err
+	}
+	for i, page := range api.pages {
+		if !fn(&cur.DescribeReportDefinitionsOutput{ReportDefinitions: page}, i == len(api.pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func fakeReportDefinition(name, bucket, prefix, region string) *cur.ReportDefinition {
+	return &cur.ReportDefinition{
+		ReportName: aws.String(name),
+		S3Bucket:   aws.String(bucket),
+		S3Prefix:   aws.String(prefix),
+		S3Region:   aws.String(region),
+	}
+}
+
+func TestGetReportDefinition(t *testing.T) {
+	other := fakeReportDefinition("other", "other-bucket", "other", "us-east-1")
+	wanted := fakeReportDefinition("report", "bucket", "daily-report", "eu-west-1")
+
+	t.Run("Definition on second page", func(t *testing.T) {
+		api := fakeReportAPI{pages: [][]*cur.ReportDefinition{{other}, {wanted}}}
+		actual, err := getReportDefinition(context.Background(), api, "report")
+		if err != nil {
+			t.Errorf("Caught error: %s", err)
+		}
+		if actual != wanted {
+			t.Errorf("Expected definition %v, got %v", wanted, actual)
+		}
+	})
+
+	t.Run("Missing definition", func(t *testing.T) {
+		api := fakeReportAPI{pages: [][]*cur.ReportDefinition{{other}}}
+		_, err := getReportDefinition(context.Background(), api, "report")
+		if err == nil {
+			t.Errorf("Expected error but got none!")
+		}
+	})
+
+	t.Run("Error from API", func(t *testing.T) {
+		api := fakeReportAPI{err: errors.New("error")}
+		_, err := getReportDefinition(context.Background(), api, "report")
+		if err == nil {
+			t.Errorf("Expected error but got none!")
+		}
+	})
+}
+
+func TestConfigFromDefinition(t *testing.T) {
+	definition := fakeReportDefinition("report", "bucket", "daily-report", "eu-west-1")
+
+	actual := configFromDefinition(Config{ReportName: "report", SessionConfig: SessionConfig{Profile: "billing"}}, definition)
+	expected := Config{ReportName: "report", Bucket: "bucket", ReportPrefix: "daily-report", Region: "eu-west-1", SessionConfig: SessionConfig{Profile: "billing"}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected config %+v, got %+v", expected, actual)
+	}
+
+	// An explicit region is kept
+	actual = configFromDefinition(Config{ReportName: "report", Region: "us-west-2"}, definition)
+	if actual.Region != "us-west-2" {
+		t.Errorf("Expected region us-west-2, got %s", actual.Region)
+	}
+}
+
+func TestLocateReport(t *testing.T) {
+	calls := 0
+	client := Client{
+		reportName: "report",
+		locate: func(ctx context.Context) (Config, error) {
+			calls++
+			if calls == 1 {
+				return Config{}, errors.New("throttled")
+			}
+			return Config{Bucket: "bucket", ReportPrefix: "daily-report", Region: "eu-west-1"}, nil
+		},
+		newService: func(config Config) s3API {
+			return &fakeS3{}
+		},
+	}
+
+	// A failed request is retried on the next call
+	if err := client.locateReport(context.Background()); err == nil || client.service != nil {
+		t.Errorf("Expected error without service but got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := client.locateReport(context.Background()); err != nil {
+			t.Fatalf("Caught error: %s", err)
+		}
+	}
+	if calls != 2 || client.bucket != "bucket" || client.reportPrefix != "daily-report" || client.service == nil {
+		t.Errorf("Expected the location to be read once more and kept but got %d calls and %+v", calls, client)
+	}
+}
@@ -0,0 +1,88 @@
+package aws
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// SessionConfig describes the credentials and endpoint of the clients.
+// Without static keys or a secrets file the default chain of the SDK is used:
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, the shared config of the profile and the instance role.
+type SessionConfig struct {
+	// Profile of the shared config, and of SecretsFile. Empty means the default profile.
+	Profile string
+	// AccessKeyID and SecretAccessKey are static keys, SessionToken is only needed for temporary keys
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// SecretsFile is a file with keys in the format of the shared credentials file, e.g. a mounted secret
+	SecretsFile string
+	// RoleARN is assumed with the credentials above, or with the token of WebIdentityTokenFile if set
+	RoleARN              string
+	ExternalID           string
+	RoleSessionName      string
+	WebIdentityTokenFile string
+	// Endpoint replaces the endpoint of the S3 or Cost Explorer service, e.g. a local MinIO server.
+	// Buckets are then addressed by path instead of by host name.
+	Endpoint string
+}
+
+// newSession creates a session with the credentials of the configuration
+func newSession(config SessionConfig) (*session.Session, error) {
+	if (config.AccessKeyID == "") != (config.SecretAccessKey == "") {
+		return nil, fmt.Errorf("both the access key ID and the secret access key must be set")
+	}
+	if config.AccessKeyID != "" && config.SecretsFile != "" {
+		return nil, fmt.Errorf("static keys can not be combined with a secrets file")
+	}
+	if config.RoleARN == "" && (config.ExternalID != "" || config.RoleSessionName != "" || config.WebIdentityTokenFile != "") {
+		return nil, fmt.Errorf("an external ID, role session name or web identity token needs a role to assume")
+	}
+
+	options := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Profile:           config.Profile,
+	}
+	if config.AccessKeyID != "" {
+		options.Config.Credentials = credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, config.SessionToken)
+	} else if config.SecretsFile != "" {
+		options.Config.Credentials = credentials.NewSharedCredentials(config.SecretsFile, config.Profile)
+	}
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.RoleARN == "" {
+		return sess, nil
+	}
+	var creds *credentials.Credentials
+	if config.WebIdentityTokenFile != "" {
+		creds = stscreds.NewWebIdentityCredentials(sess, config.RoleARN, config.RoleSessionName, config.WebIdentityTokenFile)
+	} else {
+		creds = stscreds.NewCredentials(sess, config.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if config.ExternalID != "" {
+				p.ExternalID = aws.String(config.ExternalID)
+			}
+			if config.RoleSessionName != "" {
+				p.RoleSessionName = config.RoleSessionName
+			}
+		})
+	}
+	return sess.Copy(aws.NewConfig().WithCredentials(creds)), nil
+}
+
+// serviceConfig returns the configuration of a service in the region, at the endpoint if it is set
+func serviceConfig(region string, config SessionConfig) *aws.Config {
+	awsConfig := aws.NewConfig()
+	if region != "" {
+		awsConfig = awsConfig.WithRegion(region)
+	}
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint).WithS3ForcePathStyle(true)
+	}
+	return awsConfig
+}
@@ -0,0 +1,71 @@
+package aws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSession(t *testing.T) {
+	cases := []struct {
+		name   string
+		config SessionConfig
+	}{
+		{"Access key without secret", SessionConfig{AccessKeyID: "id"}},
+		{"Static keys and secrets file", SessionConfig{AccessKeyID: "id", SecretAccessKey: "secret", SecretsFile: "/run/secrets/aws"}},
+		{"External ID without role", SessionConfig{ExternalID: "cct"}},
+		{"Web identity without role", SessionConfig{WebIdentityTokenFile: "/var/run/secrets/token"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := newSession(c.config); err == nil {
+				t.Errorf("Expected error but got none!")
+			}
+		})
+	}
+
+	config := SessionConfig{AccessKeyID: "id", SecretAccessKey: "secret", RoleARN: "arn:aws:iam::123456789012:role/billing", ExternalID: "cct"}
+	if _, err := newSession(config); err != nil {
+		t.Errorf("Caught error: %s", err)
+	}
+}
+
+func TestCustomEndpoint(t *testing.T) {
+	// A local stand-in of an S3 compatible server, e.g. MinIO, with path style bucket URLs
+	var paths, authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if r.URL.Path != "/billing/daily-report/test-usage-report/20180801-20180901/test-usage-report-Manifest.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(manifestJSON))
+	}))
+	defer server.Close()
+
+	client := NewClient(Config{
+		ReportName:    "test-usage-report",
+		Bucket:        "billing",
+		ReportPrefix:  "daily-report",
+		Region:        "us-east-1",
+		SessionConfig: SessionConfig{AccessKeyID: "minio", SecretAccessKey: "minio123", Endpoint: server.URL},
+	})
+	m, err := client.getManifest(context.Background(), time.Date(2018, time.August, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	if m.AssemblyID != "a1b2c3" {
+		t.Errorf("Expected assembly a1b2c3, got %s", m.AssemblyID)
+	}
+	if len(paths) != 1 || !strings.HasPrefix(paths[0], "/billing/") {
+		t.Errorf("Expected one request with the bucket in the path but got %v", paths)
+	}
+	if len(authorizations) != 1 || !strings.Contains(authorizations[0], "Credential=minio/") {
+		t.Errorf("Expected a request signed with the static keys but got %v", authorizations)
+	}
+}
@@ -13,11 +13,12 @@ import (
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
-// Client interface is made to simplify testing
+// Client interface is made to simplify testing.
+// The context of each call is used for the request of the first page.
 type Client interface {
-	getPeriodIterator(subscriptionID, filter string) (periodsIterator, error)
-	getUsageIterator(subscriptionID, billingPeriod, filter string) (usageIterator, error)
-	getSubscriptionIterator() (subscriptionIterator, error)
+	getPeriodIterator(ctx context.Context, subscriptionID, filter string) (periodsIterator, error)
+	getUsageIterator(ctx context.Context, subscriptionID, billingPeriod, filter string) (usageIterator, error)
+	getSubscriptionIterator(ctx context.Context) (subscriptionIterator, error)
 }
 
 // RestClient is a simple implementation of Client
@@ -41,26 +42,34 @@ type subscriptionClient interface {
 }
 
 type usageIterator interface {
-	Next() error
+	NextWithContext(ctx context.Context) error
 	NotDone() bool
 	Value() consumption.UsageDetail
 }
 
 type periodsIterator interface {
-	Next() error
+	NextWithContext(ctx context.Context) error
 	NotDone() bool
 	Value() billing.Period
 }
 
 type subscriptionIterator interface {
-	Next() error
+	NextWithContext(ctx context.Context) error
 	NotDone() bool
 	Value() subscription.Model
 }
 
-// NewRestClient returns a RestClient for the given subscription ID.
-func NewRestClient() Client {
-	authorizer, err := auth.NewAuthorizerFromEnvironment()
+// NewRestClient returns a RestClient for the given tenant ID.
+// The credentials are read from the environment and an empty tenant ID means AZURE_TENANT_ID.
+func NewRestClient(tenantID string) Client {
+	settings, err := auth.GetSettingsFromEnvironment()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tenantID != "" {
+		settings.Values[auth.TenantID] = tenantID
+	}
+	authorizer, err := settings.GetAuthorizer()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -86,22 +95,23 @@ func NewRestClient() Client {
 	return restClient
 }
 
-func (c RestClient) getPeriodIterator(subscriptionID, filter string) (periodsIterator, error) {
+func (c RestClient) getPeriodIterator(ctx context.Context, subscriptionID, filter string) (periodsIterator, error) {
 	periodsClient := c.newPeriodsClient(subscriptionID)
 	var top int32 = 100
-	result, err := periodsClient.ListComplete(context.Background(), filter, "", &top)
+	result, err := periodsClient.ListComplete(ctx, filter, "", &top)
 	return &result, err
 }
 
-func (c RestClient) getUsageIterator(subscriptionID, billingPeriod, filter string) (usageIterator, error) {
+func (c RestClient) getUsageIterator(ctx context.Context, subscriptionID, billingPeriod, filter string) (usageIterator, error) {
 	usageClient := c.newUsageDetailsClient(subscriptionID)
 	var top int32 = 100
-	result, err := usageClient.ListByBillingPeriodComplete(context.Background(), billingPeriod, "", filter, "", "", &top)
+	// The meter details are needed for usage that isn't of a resource
+	result, err := usageClient.ListByBillingPeriodComplete(ctx, billingPeriod, "properties/meterDetails", filter, "", "", &top)
 	return &result, err
 }
 
-func (c RestClient) getSubscriptionIterator() (subscriptionIterator, error) {
+func (c RestClient) getSubscriptionIterator(ctx context.Context) (subscriptionIterator, error) {
 	subClient := c.newSubscriptionsClient()
-	result, err := subClient.ListComplete(context.Background())
+	result, err := subClient.ListComplete(ctx)
 	return &result, err
 }
@@ -1,6 +1,7 @@
 package azure
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"log"
@@ -37,7 +38,7 @@ func TestGetPeriodIterator(t *testing.T) {
 		expected := billing.PeriodsListResultIterator{}
 		mockBilling.EXPECT().ListComplete(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(expected, err0)
 
-		_, err := client.getPeriodIterator(subscriptionID, "")
+		_, err := client.getPeriodIterator(context.Background(), subscriptionID, "")
 
 		if err == nil {
 			t.Errorf("Expected error but got none!")
@@ -49,7 +50,7 @@ func TestGetPeriodIterator(t *testing.T) {
 		expected := billing.PeriodsListResultIterator{}
 		mockBilling.EXPECT().ListComplete(gomock.Any(), filter, gomock.Any(), gomock.Any()).Return(expected, nil)
 
-		actual, err := client.getPeriodIterator(subscriptionID, filter)
+		actual, err := client.getPeriodIterator(context.Background(), subscriptionID, filter)
 		if err != nil {
 			t.Errorf("Caught error: %s", err)
 		}
@@ -81,7 +82,7 @@ func TestGetUsageIterator(t *testing.T) {
 		expected := consumption.UsageDetailsListResultIterator{}
 		mockConsumption.EXPECT().ListByBillingPeriodComplete(gomock.Any(), billingPeriod, gomock.Any(), filter, gomock.Any(), gomock.Any(), gomock.Any()).Return(expected, err0)
 
-		_, err := client.getUsageIterator(subscriptionID, billingPeriod, filter)
+		_, err := client.getUsageIterator(context.Background(), subscriptionID, billingPeriod, filter)
 
 		if err == nil {
 			t.Errorf("Expected error but got none!")
@@ -90,9 +91,9 @@ func TestGetUsageIterator(t *testing.T) {
 
 	t.Run("Get usage with filter", func(t *testing.T) {
 		expected := consumption.UsageDetailsListResultIterator{}
-		mockConsumption.EXPECT().ListByBillingPeriodComplete(gomock.Any(), billingPeriod, gomock.Any(), filter, gomock.Any(), gomock.Any(), gomock.Any()).Return(expected, nil)
+		mockConsumption.EXPECT().ListByBillingPeriodComplete(gomock.Any(), billingPeriod, "properties/meterDetails", filter, gomock.Any(), gomock.Any(), gomock.Any()).Return(expected, nil)
 
-		actual, err := client.getUsageIterator(subscriptionID, billingPeriod, filter)
+		actual, err := client.getUsageIterator(context.Background(), subscriptionID, billingPeriod, filter)
 
 		if err != nil {
 			t.Errorf("Caught error: %s", err)
@@ -122,7 +123,7 @@ func TestGetSubscriptionIterator(t *testing.T) {
 		expected := subscription.ListResultIterator{}
 		mockSubscription.EXPECT().ListComplete(gomock.Any()).Return(expected, err0)
 
-		_, err := client.getSubscriptionIterator()
+		_, err := client.getSubscriptionIterator(context.Background())
 
 		if err == nil {
 			t.Errorf("Expected error but got none!")
@@ -133,7 +134,7 @@ func TestGetSubscriptionIterator(t *testing.T) {
 		expected := subscription.ListResultIterator{}
 		mockSubscription.EXPECT().ListComplete(gomock.Any()).Return(expected, nil)
 
-		actual, err := client.getSubscriptionIterator()
+		actual, err := client.getSubscriptionIterator(context.Background())
 
 		if err != nil {
 			t.Errorf("Caught error: %s", err)
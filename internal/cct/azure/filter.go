@@ -0,0 +1,66 @@
+package azure
+
+import (
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/preview/subscription/mgmt/2018-03-01-preview/subscription"
+)
+
+// SubscriptionFilter matches subscriptions by ID, display name or state. All comparisons ignore case.
+type SubscriptionFilter struct {
+	IDs []string
+	// Names are globs of display names, e.g. team-a-*. See path.Match for the syntax.
+	Names []string
+	// States are states of subscriptions, e.g. Enabled, Disabled or Warned
+	States []string
+}
+
+// matches returns true if the subscription matches any of the IDs, names or states
+func (f SubscriptionFilter) matches(sub subscription.Model) bool {
+	return f.matchesID(sub) || f.matchesName(sub) || containsFold(f.States, string(sub.State))
+}
+
+// matchesID returns true if the subscription matches any of the IDs
+func (f SubscriptionFilter) matchesID(sub subscription.Model) bool {
+	return sub.SubscriptionID != nil && containsFold(f.IDs, *sub.SubscriptionID)
+}
+
+// matchesName returns true if the display name of the subscription matches any of the globs
+func (f SubscriptionFilter) matchesName(sub subscription.Model) bool {
+	if sub.DisplayName == nil {
+		return false
+	}
+	name := strings.ToLower(*sub.DisplayName)
+	for _, glob := range f.Names {
+		if ok, _ := path.Match(strings.ToLower(glob), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionSelected returns true if the subscription should be read. That is if its state is Enabled
+// or one of the included states, it matches the included IDs if there are any and the included names
+// if there are any, and it matches nothing that is excluded.
+func (e *UsageExplorer) subscriptionSelected(sub subscription.Model) bool {
+	if sub.State != subscription.Enabled && !containsFold(e.include.States, string(sub.State)) {
+		return false
+	}
+	if len(e.include.IDs) > 0 && !e.include.matchesID(sub) {
+		return false
+	}
+	if len(e.include.Names) > 0 && !e.include.matchesName(sub) {
+		return false
+	}
+	return !e.exclude.matches(sub)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
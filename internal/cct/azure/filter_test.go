@@ -0,0 +1,49 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/preview/subscription/mgmt/2018-03-01-preview/subscription"
+)
+
+func TestSubscriptionSelected(t *testing.T) {
+	fakeSubscription := func(id, name string, state subscription.State) subscription.Model {
+		return subscription.Model{SubscriptionID: &id, DisplayName: &name, State: state}
+	}
+	teamA := fakeSubscription(subscriptionID, "Team-A-Production", subscription.Enabled)
+	sandbox := fakeSubscription(subscriptionID2, "team-a-sandbox", subscription.Enabled)
+	warned := fakeSubscription("warned-id", "team-b", subscription.Warned)
+	disabled := fakeSubscription("disabled-id", "team-a-old", subscription.Disabled)
+
+	cases := []struct {
+		name     string
+		include  SubscriptionFilter
+		exclude  SubscriptionFilter
+		sub      subscription.Model
+		expected bool
+	}{
+		{"No filters", SubscriptionFilter{}, SubscriptionFilter{}, teamA, true},
+		{"Not enabled", SubscriptionFilter{}, SubscriptionFilter{}, warned, false},
+		{"Included state", SubscriptionFilter{States: []string{"warned"}}, SubscriptionFilter{}, warned, true},
+		{"Other included state", SubscriptionFilter{States: []string{"Warned"}}, SubscriptionFilter{}, disabled, false},
+		{"Included ID", SubscriptionFilter{IDs: []string{subscriptionID}}, SubscriptionFilter{}, teamA, true},
+		{"Not included ID", SubscriptionFilter{IDs: []string{subscriptionID}}, SubscriptionFilter{}, sandbox, false},
+		{"Included name", SubscriptionFilter{Names: []string{"team-a-*"}}, SubscriptionFilter{}, teamA, true},
+		{"Included ID and name", SubscriptionFilter{IDs: []string{subscriptionID, subscriptionID2}, Names: []string{"*-production"}}, SubscriptionFilter{}, teamA, true},
+		{"Included ID but not name", SubscriptionFilter{IDs: []string{subscriptionID, subscriptionID2}, Names: []string{"*-production"}}, SubscriptionFilter{}, sandbox, false},
+		{"Included name but not ID", SubscriptionFilter{IDs: []string{subscriptionID2}, Names: []string{"team-a-*"}}, SubscriptionFilter{}, teamA, false},
+		{"Included name of disabled", SubscriptionFilter{Names: []string{"team-a-*"}}, SubscriptionFilter{}, disabled, false},
+		{"Excluded name", SubscriptionFilter{Names: []string{"team-a-*"}}, SubscriptionFilter{Names: []string{"*-sandbox"}}, sandbox, false},
+		{"Excluded ID", SubscriptionFilter{}, SubscriptionFilter{IDs: []string{subscriptionID}}, teamA, false},
+		{"Excluded state", SubscriptionFilter{States: []string{"Warned"}}, SubscriptionFilter{States: []string{"Warned"}}, warned, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			explorer := UsageExplorer{include: c.include, exclude: c.exclude}
+			if actual := explorer.subscriptionSelected(c.sub); actual != c.expected {
+				t.Errorf("Expected %t but got %t", c.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package azure
+
+import (
+	"strings"
+)
+
+// resourceID is a parsed Azure resource ID like
+// /subscriptions/{guid}/resourceGroups/{resource-group-name}/providers/{namespace}/{type}/{name}.
+// Child resources add more types and names, e.g. .../servers/{server}/databases/{database}, and extension
+// resources add another provider, e.g. .../virtualMachines/{vm}/providers/Microsoft.Insights/diagnosticSettings/{name}.
+// See: https://docs.microsoft.com/en-us/rest/api/resources/resources/getbyid
+// Everything is lowercased since the IDs are case insensitive and the API doesn't use the same case everywhere.
+type resourceID struct {
+	subscription  string
+	resourceGroup string
+	// namespace of the resource provider, e.g. microsoft.compute
+	namespace string
+	// types of the resource and its parents, e.g. [servers databases]
+	types []string
+	// names of the resource and its parents, e.g. [server1 database1]
+	names []string
+	// unnamed is true if the ID ends with a type, e.g. a collection of resources
+	unnamed bool
+}
+
+// parseResourceID parses as much of the ID as it can. IDs of subscriptions and resource groups
+// give no resource, while anything that doesn't look like a resource ID gives an empty resourceID.
+func parseResourceID(id string) resourceID {
+	var r resourceID
+	parts := strings.Split(strings.Trim(strings.ToLower(id), "/"), "/")
+	if len(parts) < 2 || parts[0] != "subscriptions" {
+		return r
+	}
+	r.subscription = parts[1]
+	parts = parts[2:]
+
+	if len(parts) >= 2 && parts[0] == "resourcegroups" {
+		r.resourceGroup = parts[1]
+		parts = parts[2:]
+	}
+	for len(parts) >= 2 && parts[0] == "providers" {
+		// An extension resource starts over with the types of the new provider,
+		// the resource that it extends is its parent
+		r.namespace = parts[1]
+		r.types = nil
+		parts = parts[2:]
+		for len(parts) > 0 && parts[0] != "providers" {
+			r.types = append(r.types, parts[0])
+			if len(parts) == 1 {
+				r.unnamed = true
+				parts = nil
+				break
+			}
+			r.names = append(r.names, parts[1])
+			parts = parts[2:]
+		}
+	}
+	return r
+}
+
+// isResource returns true if the ID is of a resource and not only of a subscription or resource group
+func (r resourceID) isResource() bool {
+	return r.namespace != "" && len(r.types) > 0
+}
+
+// resourceType returns the full type of the resource, e.g. microsoft.sql/servers/databases
+func (r resourceID) resourceType() string {
+	return r.namespace + "/" + strings.Join(r.types, "/")
+}
+
+// name returns the name of the resource, or an empty string if the ID ends with a type
+func (r resourceID) name() string {
+	if r.unnamed || len(r.names) == 0 {
+		return ""
+	}
+	return r.names[len(r.names)-1]
+}
+
+// parent returns the names of the parents of a child or extension resource, e.g. server1
+func (r resourceID) parent() string {
+	parents := r.names
+	if !r.unnamed && len(parents) > 0 {
+		parents = parents[:len(parents)-1]
+	}
+	return strings.Join(parents, "/")
+}
@@ -0,0 +1,59 @@
+package azure
+
+import "testing"
+
+func TestParseResourceID(t *testing.T) {
+	sub := "/subscriptions/ABCD-1234/resourceGroups/My-Group"
+	cases := []struct {
+		name         string
+		id           string
+		isResource   bool
+		subscription string
+		group        string
+		resourceType string
+		instance     string
+		parent       string
+	}{
+		{"Resource", sub + "/providers/Microsoft.Compute/virtualMachines/VM1",
+			true, "abcd-1234", "my-group", "microsoft.compute/virtualmachines", "vm1", ""},
+		{"Child resource", sub + "/providers/Microsoft.Sql/servers/server1/databases/db1",
+			true, "abcd-1234", "my-group", "microsoft.sql/servers/databases", "db1", "server1"},
+		{"Extension resource", sub + "/providers/Microsoft.Compute/virtualMachines/vm1/providers/Microsoft.Insights/diagnosticSettings/logs",
+			true, "abcd-1234", "my-group", "microsoft.insights/diagnosticsettings", "logs", "vm1"},
+		{"Resource without name", sub + "/providers/Microsoft.Sql/servers/server1/databases",
+			true, "abcd-1234", "my-group", "microsoft.sql/servers/databases", "", "server1"},
+		{"Subscription level resource", "/subscriptions/abcd-1234/providers/Microsoft.Security/pricings/default",
+			true, "abcd-1234", "", "microsoft.security/pricings", "default", ""},
+		{"Trailing slash", sub + "/providers/Microsoft.Compute/disks/disk1/",
+			true, "abcd-1234", "my-group", "microsoft.compute/disks", "disk1", ""},
+		{"Resource group", sub, false, "abcd-1234", "my-group", "", "", ""},
+		{"Subscription", "/subscriptions/abcd-1234", false, "abcd-1234", "", "", "", ""},
+		{"Provider without type", sub + "/providers/Microsoft.Compute", false, "abcd-1234", "my-group", "", "", ""},
+		{"Marketplace offer", "publisher/offer/plan", false, "", "", "", "", ""},
+		{"Empty", "", false, "", "", "", "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			id := parseResourceID(c.id)
+			if id.isResource() != c.isResource {
+				t.Errorf("Expected resource %t but got %t", c.isResource, id.isResource())
+			}
+			if id.subscription != c.subscription {
+				t.Errorf("Expected subscription %s but got %s", c.subscription, id.subscription)
+			}
+			if id.resourceGroup != c.group {
+				t.Errorf("Expected resource group %s but got %s", c.group, id.resourceGroup)
+			}
+			if c.isResource && id.resourceType() != c.resourceType {
+				t.Errorf("Expected type %s but got %s", c.resourceType, id.resourceType())
+			}
+			if id.name() != c.instance {
+				t.Errorf("Expected name %s but got %s", c.instance, id.name())
+			}
+			if id.parent() != c.parent {
+				t.Errorf("Expected parent %s but got %s", c.parent, id.parent())
+			}
+		})
+	}
+}
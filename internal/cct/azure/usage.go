@@ -1,6 +1,7 @@
 package azure
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -12,42 +13,130 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/preview/billing/mgmt/2018-03-01-preview/billing"
 )
 
+// Config selects the tenant and subscriptions that a UsageExplorer reads
+type Config struct {
+	// TenantID overrides AZURE_TENANT_ID if set
+	TenantID string
+	// Subscriptions limits which subscriptions to read. Empty means all of them.
+	// It is the same as the IDs of Include.
+	Subscriptions []string
+	// Include limits which subscriptions to read to those that match one of the IDs, if any are given,
+	// and one of the names, if any are given.
+	// Subscriptions that aren't Enabled are skipped unless their state is one of the included states.
+	Include SubscriptionFilter
+	// Exclude skips the subscriptions that match any of the IDs, names or states
+	Exclude SubscriptionFilter
+	// Timeout limits every request to the API, including the request of each page. Zero means no limit.
+	Timeout time.Duration
+}
+
 // A UsageExplorer can be used to investigate usage cost
 type UsageExplorer struct {
-	client Client
+	client  Client
+	include SubscriptionFilter
+	exclude SubscriptionFilter
+	timeout time.Duration
 }
 
 // NewUsageExplorer initializes a UsageExplorer
-func NewUsageExplorer() UsageExplorer {
-	return UsageExplorer{client: NewRestClient()}
+func NewUsageExplorer(config Config) UsageExplorer {
+	include := config.Include
+	include.IDs = append(append([]string{}, config.Subscriptions...), include.IDs...)
+	return UsageExplorer{
+		client:  NewRestClient(config.TenantID),
+		include: include,
+		exclude: config.Exclude,
+		timeout: config.Timeout,
+	}
 }
 
-// GetCloudCost fetches the cost for the specified date
-func (e *UsageExplorer) GetCloudCost(date time.Time) ([]dbclient.UsageData, error) {
+// SubscriptionError describes a subscription whose cost could not be fetched
+type SubscriptionError struct {
+	SubscriptionID string
+	Err            error
+}
+
+func (e SubscriptionError) Error() string {
+	return fmt.Sprintf("subscription %s: %v", e.SubscriptionID, e.Err)
+}
+
+// PartialError is returned together with the cost of the other subscriptions when
+// the cost of some subscriptions could not be fetched, e.g. because of missing access
+type PartialError struct {
+	// Subscriptions is the number of subscriptions that were read
+	Subscriptions int
+	Errors        []SubscriptionError
+}
+
+func (e *PartialError) Error() string {
+	failed := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		failed[i] = err.Error()
+	}
+	return fmt.Sprintf("%d of %d subscriptions could not be read: %s", len(e.Errors), e.Subscriptions, strings.Join(failed, "; "))
+}
+
+// pager is an iterator that requests the next page of results when needed
+type pager interface {
+	NextWithContext(ctx context.Context) error
+}
+
+// requestContext returns the context of one request, with the deadline of the timeout if set
+func (e *UsageExplorer) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if e.timeout > 0 {
+		return context.WithTimeout(ctx, e.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// next moves the iterator forward. Stops if the context is done, even if the next value is already fetched.
+func (e *UsageExplorer) next(ctx context.Context, it pager) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx, cancel := e.requestContext(ctx)
+	defer cancel()
+	return it.NextWithContext(ctx)
+}
+
+// GetCloudCost fetches the cost for the specified date.
+// A subscription that fails doesn't stop the others, their cost is returned with a *PartialError
+// naming the failed subscriptions. Stops with the error of the context if it is done before all
+// subscriptions are read.
+func (e *UsageExplorer) GetCloudCost(ctx context.Context, date time.Time) ([]dbclient.UsageData, error) {
 	var data []dbclient.UsageData
-	subscriptions, err := e.getSubscriptions()
+	subscriptions, err := e.getSubscriptions(ctx)
 	if err != nil {
 		return data, err
 	}
+	var errs []SubscriptionError
 	for _, sub := range subscriptions {
 		log.Println("Trying to get cost for subscription", sub)
-		subCost, err := e.getSubscriptionCost(sub, date)
-		if err == nil {
-			data = append(data, subCost...)
-		} else {
+		subCost, err := e.getSubscriptionCost(ctx, sub, date)
+		if ctx.Err() != nil {
+			return data, ctx.Err()
+		}
+		if err != nil {
 			log.Println("Warning: Unable to get cost for subscription", sub, err)
-			return data, err
+			errs = append(errs, SubscriptionError{SubscriptionID: sub, Err: err})
+			continue
 		}
+		data = append(data, subCost...)
 	}
 
+	if len(errs) > 0 {
+		return data, &PartialError{Subscriptions: len(subscriptions), Errors: errs}
+	}
 	return data, nil
 }
 
-func (e *UsageExplorer) getPeriodByDate(subscriptionID string, date time.Time) (billing.Period, error) {
+func (e *UsageExplorer) getPeriodByDate(ctx context.Context, subscriptionID string, date time.Time) (billing.Period, error) {
 	dateStr := date.Format("2006-01-02")
 	filter := "billingPeriodEndDate gt " + dateStr
 
-	periods, err := e.client.getPeriodIterator(subscriptionID, filter)
+	reqCtx, cancel := e.requestContext(ctx)
+	defer cancel()
+	periods, err := e.client.getPeriodIterator(reqCtx, subscriptionID, filter)
 	if err != nil {
 		return billing.Period{}, err
 	}
@@ -56,16 +145,18 @@ func (e *UsageExplorer) getPeriodByDate(subscriptionID string, date time.Time) (
 	return periods.Value(), nil
 }
 
-func (e *UsageExplorer) getUsageByDate(subscriptionID string, date time.Time) (usageIterator, error) {
-	billingPeriod, err := e.getPeriodByDate(subscriptionID, date)
+func (e *UsageExplorer) getUsageByDate(ctx context.Context, subscriptionID string, date time.Time) (usageIterator, error) {
+	billingPeriod, err := e.getPeriodByDate(ctx, subscriptionID, date)
 	if err != nil {
 		return &consumption.UsageDetailsListResultIterator{}, err
 	}
 	billingPeriodName := *billingPeriod.Name
-	filter := fmt.Sprintf("properties/usageStart eq '%s'", date.Format("2006-01-02"))
+	filter := usageFilter(date)
 	log.Println("Trying to get usage for billing period", billingPeriodName)
 
-	result, err := e.client.getUsageIterator(subscriptionID, billingPeriodName, filter)
+	reqCtx, cancel := e.requestContext(ctx)
+	defer cancel()
+	result, err := e.client.getUsageIterator(reqCtx, subscriptionID, billingPeriodName, filter)
 	if err != nil {
 		return &consumption.UsageDetailsListResultIterator{}, err
 	}
@@ -73,19 +164,32 @@ func (e *UsageExplorer) getUsageByDate(subscriptionID string, date time.Time) (u
 	return result, nil
 }
 
-func (e *UsageExplorer) getSubscriptions() ([]string, error) {
+// usageFilter selects the usage of the day. The API only returns one row per day and meter.
+func usageFilter(date time.Time) string {
+	return fmt.Sprintf("properties/usageStart eq '%s'", date.Format("2006-01-02"))
+}
+
+func (e *UsageExplorer) getSubscriptions(ctx context.Context) ([]string, error) {
 	result := []string{}
-	subIter, err := e.client.getSubscriptionIterator()
+	reqCtx, cancel := e.requestContext(ctx)
+	subIter, err := e.client.getSubscriptionIterator(reqCtx)
+	cancel()
 	if err != nil {
 		return result, err
 	}
 
 	for subIter.NotDone() {
 		sub := subIter.Value()
-		subIter.Next()
+		if err := e.next(ctx, subIter); err != nil {
+			return result, err
+		}
 		if sub.SubscriptionID == nil {
 			continue
 		}
+		if !e.subscriptionSelected(sub) {
+			log.Println("Skipping subscription", *sub.SubscriptionID, "that is not selected or", sub.State)
+			continue
+		}
 
 		result = append(result, *sub.SubscriptionID)
 	}
@@ -93,59 +197,90 @@ func (e *UsageExplorer) getSubscriptions() ([]string, error) {
 	return result, err
 }
 
-func (e *UsageExplorer) getSubscriptionCost(subscriptionID string, date time.Time) ([]dbclient.UsageData, error) {
+func (e *UsageExplorer) getSubscriptionCost(ctx context.Context, subscriptionID string, date time.Time) ([]dbclient.UsageData, error) {
 	var data []dbclient.UsageData
-	usageIter, err := e.getUsageByDate(subscriptionID, date)
+	usageIter, err := e.getUsageByDate(ctx, subscriptionID, date)
 	if err != nil {
 		return data, err
 	}
 
 	for usageIter.NotDone() {
 		usageDetails := usageIter.Value()
-		usageIter.Next()
+		if err := e.next(ctx, usageIter); err != nil {
+			return data, err
+		}
 		// Check that fields actually exist!
 		if !propertiesOK(usageDetails) {
 			continue
 		}
 
-		instanceID := *usageDetails.InstanceID
+		instanceID := ""
+		if usageDetails.InstanceID != nil {
+			instanceID = *usageDetails.InstanceID
+		}
 		pretaxCost := *usageDetails.PretaxCost
 		currency := *usageDetails.Currency
 		usageStart := *usageDetails.UsageStart
 
-		labels := getLabels(instanceID, currency)
+		labels := getLabels(subscriptionID, instanceID, meterCategory(usageDetails), currency)
 		log.Println(pretaxCost, currency, usageStart.Format("2006-01-02 15:04"), labels)
 
 		cost, _ := pretaxCost.Float64()
 
-		data = append(data, dbclient.UsageData{Cost: cost, Date: date, Labels: labels})
+		// The full resource ID is unique, so it is not a label
+		var attributes map[string]string
+		if instanceID != "" {
+			attributes = map[string]string{"resource_id": instanceID}
+		}
+
+		data = append(data, dbclient.UsageData{Cost: cost, Date: date, Labels: labels, Attributes: attributes})
 	}
 
 	return data, nil
 }
 
-func getLabels(instanceID, currency string) map[string]string {
-	// The instance ID is a string like this:
-	// /subscriptions/{guid}/resourceGroups/{resource-group-name}/{resource-provider-namespace}/{resource-type}/{subtype}/{resource-name}
-	// See: https://docs.microsoft.com/en-us/rest/api/resources/resources/getbyid
-	// We extract the provider by splitting on /
-	parts := strings.Split(instanceID, "/")
+// getLabels labels the usage with the parts of the instance ID. Usage that isn't of a resource,
+// e.g. of a marketplace offer or support plan, gets the meter category as service instead.
+func getLabels(subscriptionID, instanceID, category, currency string) map[string]string {
+	id := parseResourceID(instanceID)
 	labels := make(map[string]string)
 	labels["cloud"] = "azure"
-	labels["subscription"] = parts[2]
-	labels["resource_group"] = parts[4]
-	labels["service"] = strings.Join(parts[6:8], "/")
+	labels["subscription"] = strings.ToLower(subscriptionID)
+	if id.subscription != "" {
+		labels["subscription"] = id.subscription
+	}
+	if id.resourceGroup != "" {
+		labels["resource_group"] = id.resourceGroup
+	}
+	if id.isResource() {
+		labels["service"] = id.resourceType()
+	} else if category != "" {
+		labels["service"] = strings.ToLower(category)
+	}
+	if name := id.name(); name != "" {
+		labels["instance"] = name
+	}
+	if parent := id.parent(); parent != "" {
+		labels["parent"] = parent
+	}
 	labels["currency"] = currency
-	labels["instance"] = parts[8]
 	return labels
 }
 
+// meterCategory returns the category of the meter, e.g. Virtual Machines, or an empty string if it is unknown
+func meterCategory(usageDetails consumption.UsageDetail) string {
+	if usageDetails.MeterDetails == nil || usageDetails.MeterDetails.MeterCategory == nil {
+		return ""
+	}
+	return *usageDetails.MeterDetails.MeterCategory
+}
+
+// propertiesOK checks that the usage has a cost. The instance ID is optional since not all usage is of a resource.
 func propertiesOK(usageDetails consumption.UsageDetail) bool {
 	if (usageDetails.UsageDetailProperties == nil) ||
 		(usageDetails.UsageStart == nil) ||
 		(usageDetails.PretaxCost == nil) ||
-		(usageDetails.Currency == nil) ||
-		(usageDetails.InstanceID == nil) {
+		(usageDetails.Currency == nil) {
 		return false
 	}
 	return true
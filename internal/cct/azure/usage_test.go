@@ -1,9 +1,11 @@
 package azure
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
@@ -47,9 +49,9 @@ var (
 	instanceID      = "/subscriptions/" + subscriptionID + "/resourceGroups/" + resourceGroup + "/providers/" + provider + "/" + instance
 	instanceID2     = "/subscriptions/" + subscriptionID2 + "/resourceGroups/" + resourceGroup + "/providers/" + provider2 + "/" + instance2
 	instanceID3     = "/subscriptions/" + subscriptionID + "/resourceGroups/" + resourceGroup + "/providers/" + provider2 + "/" + instance2
-	labels          = map[string]string{"cloud": "azure", "subscription": subscriptionID, "resource_group": resourceGroup, "service": provider, "instance": instance, "currency": currency}
-	labels2         = map[string]string{"cloud": "azure", "subscription": subscriptionID2, "resource_group": resourceGroup, "service": provider2, "instance": instance2, "currency": currency2}
-	labels3         = map[string]string{"cloud": "azure", "subscription": subscriptionID, "resource_group": resourceGroup, "service": provider2, "instance": instance2, "currency": currency}
+	labels          = map[string]string{"cloud": "azure", "subscription": subscriptionID, "resource_group": resourceGroup, "service": strings.ToLower(provider), "instance": instance, "currency": currency}
+	labels2         = map[string]string{"cloud": "azure", "subscription": subscriptionID2, "resource_group": resourceGroup, "service": strings.ToLower(provider2), "instance": instance2, "currency": currency2}
+	labels3         = map[string]string{"cloud": "azure", "subscription": subscriptionID, "resource_group": resourceGroup, "service": strings.ToLower(provider2), "instance": instance2, "currency": currency}
 	usageData       = dbclient.UsageData{Cost: cost, Date: usageDate, Labels: labels}
 	usageData2      = dbclient.UsageData{Cost: cost, Date: usageDate, Labels: labels2}
 	usageData3      = dbclient.UsageData{Cost: cost, Date: usageDate, Labels: labels3}
@@ -68,8 +70,8 @@ var (
 
 // Faked data from API
 var (
-	subscription1 = subscription.Model{SubscriptionID: &subscriptionID}
-	subscription2 = subscription.Model{SubscriptionID: &subscriptionID2}
+	subscription1 = subscription.Model{SubscriptionID: &subscriptionID, State: subscription.Enabled}
+	subscription2 = subscription.Model{SubscriptionID: &subscriptionID2, State: subscription.Enabled}
 	usageDetail   = fakeUsageDetail(usageDate, cost, currency, instanceID)
 	usageDetail2  = fakeUsageDetail(usageDate, cost, currency2, instanceID2)
 	usageDetail3  = fakeUsageDetail(usageDate, cost, currency, instanceID3)
@@ -114,7 +116,7 @@ func TestGetCloudCost(t *testing.T) {
 		setupClient(*mockClient, mockSubscriptionsIter, mockPeriodsIter, mockUsageIter, c.in)
 		setupIterators(*mockSubscriptionsIter, *mockPeriodsIter, *mockUsageIter, c.in)
 
-		actual, err := ue.GetCloudCost(usageDate)
+		actual, err := ue.GetCloudCost(context.Background(), usageDate)
 		if err != nil {
 			t.Errorf("Caught error: %s", err)
 		}
@@ -126,9 +128,9 @@ func TestGetCloudCost(t *testing.T) {
 	// ------------------
 
 	t.Run("Fail to get subscriptions iterator", func(t *testing.T) {
-		mockClient.EXPECT().getSubscriptionIterator().Return(mockSubscriptionsIter, errors.New("error"))
+		mockClient.EXPECT().getSubscriptionIterator(gomock.Any()).Return(mockSubscriptionsIter, errors.New("error"))
 
-		_, err := ue.GetCloudCost(usageDate)
+		_, err := ue.GetCloudCost(context.Background(), usageDate)
 
 		if err == nil {
 			t.Errorf("Expected error but got none!")
@@ -136,14 +138,14 @@ func TestGetCloudCost(t *testing.T) {
 	})
 
 	t.Run("Fail to get period iterator", func(t *testing.T) {
-		mockClient.EXPECT().getSubscriptionIterator().Return(mockSubscriptionsIter, nil)
-		mockSubscriptionsIter.EXPECT().Next().AnyTimes()
+		mockClient.EXPECT().getSubscriptionIterator(gomock.Any()).Return(mockSubscriptionsIter, nil)
+		mockSubscriptionsIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
 		mockSubscriptionsIter.EXPECT().NotDone().Return(true)
 		mockSubscriptionsIter.EXPECT().Value().Return(subscription1)
 		mockSubscriptionsIter.EXPECT().NotDone().Return(false)
-		mockClient.EXPECT().getPeriodIterator(subscriptionID, gomock.Any()).Return(mockPeriodsIter, errors.New("error"))
+		mockClient.EXPECT().getPeriodIterator(gomock.Any(), subscriptionID, gomock.Any()).Return(mockPeriodsIter, errors.New("error"))
 
-		_, err := ue.GetCloudCost(usageDate)
+		_, err := ue.GetCloudCost(context.Background(), usageDate)
 
 		if err == nil {
 			t.Errorf("Expected error but got none!")
@@ -152,15 +154,15 @@ func TestGetCloudCost(t *testing.T) {
 
 	t.Run("Fail to get usage iterator", func(t *testing.T) {
 		mockPeriodsIter.EXPECT().Value().Return(period)
-		mockClient.EXPECT().getSubscriptionIterator().Return(mockSubscriptionsIter, nil)
-		mockSubscriptionsIter.EXPECT().Next().AnyTimes()
+		mockClient.EXPECT().getSubscriptionIterator(gomock.Any()).Return(mockSubscriptionsIter, nil)
+		mockSubscriptionsIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
 		mockSubscriptionsIter.EXPECT().NotDone().Return(true)
 		mockSubscriptionsIter.EXPECT().Value().Return(subscription1)
 		mockSubscriptionsIter.EXPECT().NotDone().Return(false)
-		mockClient.EXPECT().getPeriodIterator(subscriptionID, gomock.Any()).Return(mockPeriodsIter, nil)
-		mockClient.EXPECT().getUsageIterator(subscriptionID, periodName, gomock.Any()).Return(mockUsageIter, errors.New("error"))
+		mockClient.EXPECT().getPeriodIterator(gomock.Any(), subscriptionID, gomock.Any()).Return(mockPeriodsIter, nil)
+		mockClient.EXPECT().getUsageIterator(gomock.Any(), subscriptionID, periodName, gomock.Any()).Return(mockUsageIter, errors.New("error"))
 
-		_, err := ue.GetCloudCost(usageDate)
+		_, err := ue.GetCloudCost(context.Background(), usageDate)
 
 		if err == nil {
 			t.Errorf("Expected error but got none!")
@@ -168,9 +170,213 @@ func TestGetCloudCost(t *testing.T) {
 	})
 }
 
+func TestGetCloudCostSelectedSubscriptions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockSubscriptionsIter := NewMocksubscriptionIterator(mockCtrl)
+	mockPeriodsIter := NewMockperiodsIterator(mockCtrl)
+	mockUsageIter := NewMockusageIterator(mockCtrl)
+	mockClient := NewMockClient(mockCtrl)
+	ue := UsageExplorer{client: mockClient, include: SubscriptionFilter{IDs: []string{subscriptionID2}}}
+
+	// Both subscriptions are visible but only the second one is selected
+	mockClient.EXPECT().getSubscriptionIterator(gomock.Any()).Return(mockSubscriptionsIter, nil)
+	mockSubscriptionsIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
+	mockSubscriptionsIter.EXPECT().NotDone().Return(true)
+	mockSubscriptionsIter.EXPECT().Value().Return(subscription1)
+	mockSubscriptionsIter.EXPECT().NotDone().Return(true)
+	mockSubscriptionsIter.EXPECT().Value().Return(subscription2)
+	mockSubscriptionsIter.EXPECT().NotDone().Return(false)
+
+	mockClient.EXPECT().getPeriodIterator(gomock.Any(), subscriptionID2, gomock.Any()).Return(mockPeriodsIter, nil)
+	mockPeriodsIter.EXPECT().Value().Return(period)
+	mockClient.EXPECT().getUsageIterator(gomock.Any(), subscriptionID2, periodName, gomock.Any()).Return(mockUsageIter, nil)
+	mockUsageIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
+	mockUsageIter.EXPECT().NotDone().Return(true)
+	mockUsageIter.EXPECT().Value().Return(usageDetail2)
+	mockUsageIter.EXPECT().NotDone().Return(false)
+
+	actual, err := ue.GetCloudCost(context.Background(), usageDate)
+	if err != nil {
+		t.Errorf("Caught error: %s", err)
+	}
+
+	checkCloudCost(t, []dbclient.UsageData{usageData2}, actual)
+}
+
+func TestGetCloudCostPartial(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockSubscriptionsIter := NewMocksubscriptionIterator(mockCtrl)
+	mockPeriodsIter := NewMockperiodsIterator(mockCtrl)
+	mockUsageIter := NewMockusageIterator(mockCtrl)
+	mockClient := NewMockClient(mockCtrl)
+	ue := UsageExplorer{client: mockClient}
+
+	// The first subscription can't be read but the second one can
+	mockClient.EXPECT().getSubscriptionIterator(gomock.Any()).Return(mockSubscriptionsIter, nil)
+	mockSubscriptionsIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
+	mockSubscriptionsIter.EXPECT().NotDone().Return(true)
+	mockSubscriptionsIter.EXPECT().Value().Return(subscription1)
+	mockSubscriptionsIter.EXPECT().NotDone().Return(true)
+	mockSubscriptionsIter.EXPECT().Value().Return(subscription2)
+	mockSubscriptionsIter.EXPECT().NotDone().Return(false)
+
+	mockClient.EXPECT().getPeriodIterator(gomock.Any(), subscriptionID, gomock.Any()).Return(mockPeriodsIter, errors.New("authorization failed"))
+	mockClient.EXPECT().getPeriodIterator(gomock.Any(), subscriptionID2, gomock.Any()).Return(mockPeriodsIter, nil)
+	mockPeriodsIter.EXPECT().Value().Return(period)
+	mockClient.EXPECT().getUsageIterator(gomock.Any(), subscriptionID2, periodName, gomock.Any()).Return(mockUsageIter, nil)
+	mockUsageIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
+	mockUsageIter.EXPECT().NotDone().Return(true)
+	mockUsageIter.EXPECT().Value().Return(usageDetail2)
+	mockUsageIter.EXPECT().NotDone().Return(false)
+
+	actual, err := ue.GetCloudCost(context.Background(), usageDate)
+
+	perr, ok := err.(*PartialError)
+	if !ok {
+		t.Fatalf("Expected PartialError but got %v", err)
+	}
+	if perr.Subscriptions != 2 || len(perr.Errors) != 1 || perr.Errors[0].SubscriptionID != subscriptionID {
+		t.Errorf("Expected subscription %s of 2 to fail but got %v", subscriptionID, perr)
+	}
+	if !strings.Contains(perr.Error(), "authorization failed") {
+		t.Errorf("Expected the reason in the error but got %s", perr)
+	}
+	checkCloudCost(t, []dbclient.UsageData{usageData2}, actual)
+}
+
+func TestGetCloudCostContext(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockSubscriptionsIter := NewMocksubscriptionIterator(mockCtrl)
+	mockClient := NewMockClient(mockCtrl)
+
+	t.Run("Cancelled while paging", func(t *testing.T) {
+		ue := UsageExplorer{client: mockClient}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		mockClient.EXPECT().getSubscriptionIterator(gomock.Any()).Return(mockSubscriptionsIter, nil)
+		mockSubscriptionsIter.EXPECT().NotDone().Return(true)
+		mockSubscriptionsIter.EXPECT().Value().Return(subscription1)
+
+		_, err := ue.GetCloudCost(ctx, usageDate)
+		if err != context.Canceled {
+			t.Errorf("Expected %v but got %v", context.Canceled, err)
+		}
+	})
+
+	t.Run("Deadline of every request", func(t *testing.T) {
+		ue := UsageExplorer{client: mockClient, timeout: time.Minute}
+		checkDeadline := func(ctx context.Context) {
+			if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+				t.Errorf("Expected a deadline within a minute but got %v", deadline)
+			}
+		}
+
+		mockClient.EXPECT().getSubscriptionIterator(gomock.Any()).DoAndReturn(func(ctx context.Context) (subscriptionIterator, error) {
+			checkDeadline(ctx)
+			return mockSubscriptionsIter, nil
+		})
+		mockSubscriptionsIter.EXPECT().NotDone().Return(true)
+		mockSubscriptionsIter.EXPECT().Value().Return(subscription1)
+		mockSubscriptionsIter.EXPECT().NextWithContext(gomock.Any()).DoAndReturn(func(ctx context.Context) error {
+			checkDeadline(ctx)
+			return context.DeadlineExceeded
+		})
+
+		_, err := ue.GetCloudCost(context.Background(), usageDate)
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected %v but got %v", context.DeadlineExceeded, err)
+		}
+	})
+}
+
+func TestUsageFilter(t *testing.T) {
+	expected := "properties/usageStart eq '2018-07-03'"
+	if actual := usageFilter(usageDate); actual != expected {
+		t.Errorf("Expected filter %s but got %s", expected, actual)
+	}
+}
+
+func TestGetLabels(t *testing.T) {
+	t.Run("Resource", func(t *testing.T) {
+		actual := getLabels(subscriptionID, instanceID, "Container Registry", currency)
+		checkLabels(t, labels, actual)
+	})
+
+	t.Run("Child resource", func(t *testing.T) {
+		id := "/subscriptions/" + subscriptionID + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.Sql/servers/Server1/databases/DB1"
+		expected := map[string]string{"cloud": "azure", "subscription": subscriptionID, "resource_group": resourceGroup,
+			"service": "microsoft.sql/servers/databases", "instance": "db1", "parent": "server1", "currency": currency}
+		checkLabels(t, expected, getLabels(subscriptionID, id, "SQL Database", currency))
+	})
+
+	t.Run("Meter category without resource", func(t *testing.T) {
+		expected := map[string]string{"cloud": "azure", "subscription": subscriptionID, "service": "azure support", "currency": currency}
+		checkLabels(t, expected, getLabels(subscriptionID, "", "Azure Support", currency))
+	})
+
+	t.Run("Marketplace offer", func(t *testing.T) {
+		expected := map[string]string{"cloud": "azure", "subscription": subscriptionID, "service": "virtual machine licenses", "currency": currency}
+		checkLabels(t, expected, getLabels(subscriptionID, "publisher/offer/plan", "Virtual Machine Licenses", currency))
+	})
+
+	t.Run("Resource group", func(t *testing.T) {
+		id := "/subscriptions/" + subscriptionID + "/resourceGroups/" + resourceGroup
+		expected := map[string]string{"cloud": "azure", "subscription": subscriptionID, "resource_group": resourceGroup, "currency": currency}
+		checkLabels(t, expected, getLabels(subscriptionID, id, "", currency))
+	})
+}
+
+func TestGetCloudCostWithoutInstance(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockClient := NewMockClient(mockCtrl)
+	mockSubsIter := NewMocksubscriptionIterator(mockCtrl)
+	mockPeriodsIter := NewMockperiodsIterator(mockCtrl)
+	mockUsageIter := NewMockusageIterator(mockCtrl)
+	explorer := UsageExplorer{client: mockClient}
+
+	usage := fakeUsageDetail(usageDate, cost, currency, "")
+	usage.InstanceID = nil
+	category := "Azure Support"
+	usage.MeterDetails = &consumption.MeterDetails{MeterCategory: &category}
+	input := []inputData{{subscription: subscription1, billingPeriod: period, usageDetails: []consumption.UsageDetail{usage}}}
+	setupIterators(*mockSubsIter, *mockPeriodsIter, *mockUsageIter, input)
+	setupClient(*mockClient, mockSubsIter, mockPeriodsIter, mockUsageIter, input)
+
+	actual, err := explorer.GetCloudCost(context.Background(), usageDate)
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+	expected := map[string]string{"cloud": "azure", "subscription": subscriptionID, "service": "azure support", "currency": currency}
+	checkCloudCost(t, []dbclient.UsageData{{Cost: cost, Date: usageDate, Labels: expected}}, actual)
+	if _, ok := actual[0].Attributes["resource_id"]; ok {
+		t.Errorf("Expected no resource ID but got %s", actual[0].Attributes["resource_id"])
+	}
+}
+
 // Helper functions
 // ----------------
 
+// Check that the labels are exactly the expected labels
+func checkLabels(t *testing.T, expected, actual map[string]string) {
+	if len(expected) != len(actual) {
+		t.Errorf("Expected labels %v but got %v", expected, actual)
+		return
+	}
+	for k, v := range expected {
+		if v != actual[k] {
+			t.Errorf("Expected: %s=%s, actual: %s=%s", k, v, k, actual[k])
+		}
+	}
+}
+
 // Create a UsageDetail object from the provided input
 func fakeUsageDetail(usageDate time.Time, cost float64, currency string, instanceID string) consumption.UsageDetail {
 	id := "id"
@@ -185,9 +391,9 @@ func fakeUsageDetail(usageDate time.Time, cost float64, currency string, instanc
 
 // Make the iterators iterate over the provided data
 func setupIterators(subsIter MocksubscriptionIterator, periodsIter MockperiodsIterator, usageIter MockusageIterator, input []inputData) {
-	subsIter.EXPECT().Next().AnyTimes()
-	usageIter.EXPECT().Next().AnyTimes()
-	periodsIter.EXPECT().Next().AnyTimes()
+	subsIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
+	usageIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
+	periodsIter.EXPECT().NextWithContext(gomock.Any()).AnyTimes()
 	for _, data := range input {
 		subsIter.EXPECT().NotDone().Return(true)
 		subsIter.EXPECT().Value().Return(data.subscription)
@@ -203,10 +409,10 @@ func setupIterators(subsIter MocksubscriptionIterator, periodsIter MockperiodsIt
 
 // Make the mocked client return desired iterators
 func setupClient(mock MockClient, subscriptionsIter subscriptionIterator, periodsIter periodsIterator, usageIter usageIterator, input []inputData) {
-	mock.EXPECT().getSubscriptionIterator().Return(subscriptionsIter, nil)
+	mock.EXPECT().getSubscriptionIterator(gomock.Any()).Return(subscriptionsIter, nil)
 	for _, data := range input {
-		mock.EXPECT().getPeriodIterator(*data.subscription.SubscriptionID, gomock.Any()).Return(periodsIter, nil)
-		mock.EXPECT().getUsageIterator(*data.subscription.SubscriptionID, gomock.Any(), gomock.Any()).Return(usageIter, nil)
+		mock.EXPECT().getPeriodIterator(gomock.Any(), *data.subscription.SubscriptionID, gomock.Any()).Return(periodsIter, nil)
+		mock.EXPECT().getUsageIterator(gomock.Any(), *data.subscription.SubscriptionID, gomock.Any(), gomock.Any()).Return(usageIter, nil)
 	}
 }
 
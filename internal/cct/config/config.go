@@ -0,0 +1,516 @@
+// Package config is responsible for reading and validating the configuration of cct
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+	"github.com/robfig/cron"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The cloud providers that can be selected
+const (
+	CloudAWS   = "aws"
+	CloudAzure = "azure"
+	CloudAll   = "all"
+)
+
+// The sources that AWS cost can be read from
+const (
+	AWSSourceReport       = "report"
+	AWSSourceCostExplorer = "cost_explorer"
+)
+
+// The cost metrics that can be read from the AWS Cost and Usage Report
+var awsCostMetrics = []string{"unblended", "blended", "net_unblended", "amortized", "public_on_demand"}
+
+// The dimensions of AWS line items that can be added as labels
+var awsDimensions = []string{"account", "region", "usage_type", "resource"}
+
+// The labels that every AWS line item has
+var awsLabels = []string{"service", "currency", "cloud", "line_item_type", "payer_account"}
+
+// The dimensions that the AWS Cost Explorer can group by, other group_by labels are tag keys
+var awsExplorerDimensions = []string{"service", "account", "region", "usage_type"}
+
+// The labels that Azure usage data can have
+var azureLabels = []string{"cloud", "subscription", "resource_group", "service", "instance", "parent", "currency"}
+
+// The states of Azure subscriptions
+var azureStates = []string{"Enabled", "Disabled", "Warned", "PastDue", "Deleted"}
+
+// Config is the complete configuration of cct
+type Config struct {
+	Database DatabaseConfig `yaml:"database"`
+	// Clouds selects which providers to fetch. Empty means all of them.
+	Clouds   []string       `yaml:"clouds"`
+	AWS      []AWSConfig    `yaml:"aws"`
+	Azure    []AzureConfig  `yaml:"azure"`
+	Schedule ScheduleConfig `yaml:"schedule"`
+	Labels   LabelRules     `yaml:"labels"`
+}
+
+// DatabaseConfig describes the InfluxDB that the cost is written to
+type DatabaseConfig struct {
+	Address  string `yaml:"address"`
+	Name     string `yaml:"name"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// AWSConfig describes one AWS payer account with a Cost and Usage Report.
+// Several payer accounts, e.g. of different organizations, are fetched independently.
+type AWSConfig struct {
+	Name string `yaml:"name"`
+	// Source is AWSSourceReport or AWSSourceCostExplorer. Empty means the report.
+	// The Cost Explorer API only uses the credentials, Endpoint, PayerAccount, GroupBy, CostMetrics and Hourly.
+	Source     string `yaml:"source"`
+	ReportName string `yaml:"report_name"`
+	// Directory reads the report from local files instead of S3, e.g. a downloaded report
+	Directory string `yaml:"directory"`
+	// Bucket, ReportPrefix and Region are read from the report definition if Bucket is empty
+	Bucket       string `yaml:"bucket"`
+	ReportPrefix string `yaml:"report_prefix"`
+	Region       string `yaml:"region"`
+	Profile      string `yaml:"profile"`
+	// Static keys, or a file with keys in the format of the shared credentials file.
+	// Without them the keys are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or the profile.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	SecretsFile     string `yaml:"secrets_file"`
+	// RoleARN is assumed with the credentials above, e.g. a role in another organization,
+	// or with the token in WebIdentityTokenFile, e.g. in Kubernetes
+	RoleARN              string `yaml:"role_arn"`
+	ExternalID           string `yaml:"external_id"`
+	RoleSessionName      string `yaml:"role_session_name"`
+	WebIdentityTokenFile string `yaml:"web_identity_token_file"`
+	// Endpoint replaces the S3 or Cost Explorer endpoint, e.g. http://localhost:9000 for MinIO
+	Endpoint string `yaml:"endpoint"`
+	// PayerAccount is written as the payer_account label. Empty means the payer account ID of the report.
+	PayerAccount string `yaml:"payer_account"`
+	// GroupBy lists the labels that line items are grouped by. Empty means all labels.
+	GroupBy []string `yaml:"group_by"`
+	// Strict fails the day if more than MaxRowErrors rows of the report can't be read
+	Strict       bool `yaml:"strict"`
+	MaxRowErrors int  `yaml:"max_row_errors"`
+	// CostMetrics lists the costs to read. The first one is written as the cost and all of them
+	// as fields if there are more than one. Empty means blended cost.
+	CostMetrics []string `yaml:"cost_metrics"`
+	// Dimensions and cost allocation tags to add as labels. Only the listed tags are added
+	// to keep the number of series down.
+	Dimensions []string `yaml:"dimensions"`
+	Tags       []string `yaml:"tags"`
+	// Hourly writes the cost of every hour instead of one value per day
+	Hourly bool `yaml:"hourly"`
+	// Timezone that days are counted in, e.g. Europe/Stockholm. Empty means UTC.
+	// Hourly needs a time zone that is a whole number of hours from UTC.
+	Timezone string `yaml:"timezone"`
+	// Window overrides the window of the schedule for this account. Zero means the window of the schedule.
+	Window int `yaml:"window"`
+}
+
+// AzureConfig describes one Azure tenant
+type AzureConfig struct {
+	Name string `yaml:"name"`
+	// TenantID overrides AZURE_TENANT_ID
+	TenantID string `yaml:"tenant_id"`
+	// Subscriptions limits which subscriptions to fetch. Empty means all of them.
+	Subscriptions []string `yaml:"subscriptions"`
+	// Include limits the subscriptions to those with one of the IDs, if any, and one of the names, if any,
+	// and fetches those in the states. The IDs are added to Subscriptions.
+	// Subscriptions that aren't Enabled are skipped unless their state is included.
+	Include SubscriptionFilter `yaml:"include"`
+	// Exclude skips the subscriptions with any of the IDs, names or states
+	Exclude SubscriptionFilter `yaml:"exclude"`
+	// Hourly can't be used since the consumption API only returns the usage of whole days.
+	// It is kept so that the problem can be reported.
+	Hourly bool `yaml:"hourly"`
+	// Timeout limits every request to the Azure API, e.g. 2m. Zero means no limit.
+	Timeout time.Duration `yaml:"timeout"`
+	// WritePartial writes the cost of the subscriptions that could be read even if others failed
+	WritePartial bool `yaml:"write_partial"`
+	// Window overrides the window of the schedule for this tenant. Zero means the window of the schedule.
+	Window int `yaml:"window"`
+}
+
+// SubscriptionFilter matches Azure subscriptions by ID, display name glob or state
+type SubscriptionFilter struct {
+	IDs    []string `yaml:"ids"`
+	Names  []string `yaml:"names"`
+	States []string `yaml:"states"`
+}
+
+// ScheduleConfig controls when the daemon fetches data
+type ScheduleConfig struct {
+	Cron     string        `yaml:"cron"`
+	Interval time.Duration `yaml:"interval"`
+	// Window is the number of days, counting today, that are fetched every time.
+	// Accounts and tenants can override it.
+	Window int `yaml:"window"`
+}
+
+// LabelRules modifies the labels of all UsageData before it is written
+type LabelRules struct {
+	Add  map[string]string `yaml:"add"`
+	Drop []string          `yaml:"drop"`
+}
+
+// Default returns the configuration used when no file is given.
+// It has no cloud accounts, they must be declared in a file.
+func Default() Config {
+	return Config{
+		Database: DatabaseConfig{
+			Address:  "http://localhost:8086",
+			Name:     "cloudCostTracker",
+			Username: "cctUser",
+			Password: "cctPassword",
+		},
+		Schedule: ScheduleConfig{Window: 3},
+	}
+}
+
+// Load reads a YAML configuration file.
+// Values missing from the file are taken from the defaults.
+func Load(path string) (Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return Parse(content)
+}
+
+// Parse reads a YAML configuration
+func Parse(content []byte) (Config, error) {
+	config := Default()
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return Config{}, err
+	}
+	config.Clouds = SplitClouds(strings.Join(config.Clouds, ","))
+	return config, nil
+}
+
+// ApplyEnvironment overrides the configuration with environment variables.
+// The lookup function is normally os.LookupEnv.
+func (config *Config) ApplyEnvironment(lookup func(string) (string, bool)) []error {
+	var errs []error
+
+	fields := map[string]*string{
+		"CCT_DB_ADDRESS":  &config.Database.Address,
+		"CCT_DB_NAME":     &config.Database.Name,
+		"CCT_DB_USERNAME": &config.Database.Username,
+		"CCT_DB_PASSWORD": &config.Database.Password,
+		"CCT_SCHEDULE":    &config.Schedule.Cron,
+	}
+	for name, field := range fields {
+		if value, ok := lookup(name); ok {
+			*field = value
+		}
+	}
+
+	if value, ok := lookup("CCT_CLOUD"); ok {
+		config.Clouds = SplitClouds(value)
+	}
+	if value, ok := lookup("CCT_INTERVAL"); ok {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CCT_INTERVAL: %v", err))
+		}
+		config.Schedule.Interval = interval
+	}
+	if value, ok := lookup("CCT_WINDOW"); ok {
+		window, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CCT_WINDOW: %v", err))
+		}
+		config.Schedule.Window = window
+	}
+
+	return errs
+}
+
+// SplitClouds splits a comma separated list of cloud providers
+func SplitClouds(value string) []string {
+	var clouds []string
+	for _, cloud := range strings.Split(value, ",") {
+		if cloud = strings.ToLower(strings.TrimSpace(cloud)); cloud != "" {
+			clouds = append(clouds, cloud)
+		}
+	}
+	return clouds
+}
+
+// CloudEnabled returns true if the cloud provider should be fetched
+func (config *Config) CloudEnabled(cloud string) bool {
+	if len(config.Clouds) == 0 {
+		return true
+	}
+	for _, enabled := range config.Clouds {
+		if enabled == cloud || enabled == CloudAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the whole configuration and returns every problem that is found
+func (config *Config) Validate() []error {
+	var errs []error
+	addError := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if config.Database.Address == "" {
+		addError("database: address must be set")
+	} else if u, err := url.Parse(config.Database.Address); err != nil || u.Scheme == "" || u.Host == "" {
+		addError("database: address %q is not a valid URL", config.Database.Address)
+	}
+	if config.Database.Name == "" {
+		addError("database: name must be set")
+	}
+
+	for _, cloud := range config.Clouds {
+		if cloud != CloudAWS && cloud != CloudAzure && cloud != CloudAll {
+			addError("clouds: cloud provider %q is not supported", cloud)
+		} else if cloud == CloudAWS && len(config.AWS) == 0 {
+			addError("clouds: aws is selected but no aws accounts are configured")
+		} else if cloud == CloudAzure && len(config.Azure) == 0 {
+			addError("clouds: azure is selected but no azure tenants are configured")
+		}
+	}
+	if len(config.AWS)+len(config.Azure) == 0 {
+		addError("no aws accounts or azure tenants are configured, declare them in the file given with --config or CCT_CONFIG")
+	}
+
+	names := map[string]bool{}
+	checkName := func(section string, i int, name string) {
+		if name == "" {
+			addError("%s[%d]: name must be set", section, i)
+		} else if names[name] {
+			addError("%s[%d]: name %q is used more than once", section, i, name)
+		}
+		names[name] = true
+	}
+
+	for i, account := range config.AWS {
+		checkName(CloudAWS, i, account.Name)
+		switch account.Source {
+		case "", AWSSourceReport:
+		case AWSSourceCostExplorer:
+			if account.Directory != "" || account.Bucket != "" || account.ReportPrefix != "" {
+				addError("aws[%d]: directory, bucket and report_prefix can not be used with source %s", i, AWSSourceCostExplorer)
+			}
+			if len(account.GroupBy) > 2 {
+				addError("aws[%d]: group_by can have at most 2 labels with source %s", i, AWSSourceCostExplorer)
+			}
+			for _, label := range account.GroupBy {
+				if !contains(awsExplorerDimensions, label) && (contains(awsDimensions, label) || contains(awsLabels, label)) {
+					addError("aws[%d]: group_by %q is not available with source %s, must be one of %s or a tag key",
+						i, label, AWSSourceCostExplorer, strings.Join(awsExplorerDimensions, ", "))
+				}
+			}
+			if account.Timezone != "" || len(account.Dimensions) > 0 || len(account.Tags) > 0 || account.Strict || account.MaxRowErrors != 0 {
+				addError("aws[%d]: timezone, dimensions, tags, strict and max_row_errors can not be used with source %s", i, AWSSourceCostExplorer)
+			}
+			if contains(account.CostMetrics, "public_on_demand") {
+				addError("aws[%d]: cost metric public_on_demand is not available with source %s", i, AWSSourceCostExplorer)
+			}
+		default:
+			addError("aws[%d]: unknown source %q, must be %s or %s", i, account.Source, AWSSourceReport, AWSSourceCostExplorer)
+		}
+		if account.Directory != "" {
+			if account.Source != AWSSourceCostExplorer && (account.Bucket != "" || account.ReportPrefix != "") {
+				addError("aws[%d]: directory can not be combined with bucket or report_prefix", i)
+			}
+		} else if account.ReportName == "" && account.Source != AWSSourceCostExplorer {
+			addError("aws[%d]: report_name must be set", i)
+		}
+		if account.Bucket == "" && account.ReportPrefix != "" && account.Source != AWSSourceCostExplorer {
+			addError("aws[%d]: report_prefix is read from the report definition and can only be set together with bucket", i)
+		}
+		if account.RoleARN != "" && !strings.HasPrefix(account.RoleARN, "arn:") {
+			addError("aws[%d]: role_arn %q is not an ARN", i, account.RoleARN)
+		}
+		if account.RoleARN == "" && (account.ExternalID != "" || account.RoleSessionName != "" || account.WebIdentityTokenFile != "") {
+			addError("aws[%d]: external_id, role_session_name and web_identity_token_file can only be set together with role_arn", i)
+		}
+		if (account.AccessKeyID == "") != (account.SecretAccessKey == "") {
+			addError("aws[%d]: access_key_id and secret_access_key must be set together", i)
+		}
+		if account.AccessKeyID != "" && account.SecretsFile != "" {
+			addError("aws[%d]: only one of access_key_id and secrets_file can be set", i)
+		}
+		if account.Endpoint != "" {
+			if u, err := url.Parse(account.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				addError("aws[%d]: endpoint %q is not an http or https URL", i, account.Endpoint)
+			}
+		}
+		if account.MaxRowErrors < 0 {
+			addError("aws[%d]: max_row_errors must not be negative", i)
+		}
+		for _, metric := range account.CostMetrics {
+			if !contains(awsCostMetrics, metric) {
+				addError("aws[%d]: unknown cost metric %q, must be one of %s", i, metric, strings.Join(awsCostMetrics, ", "))
+			}
+		}
+		if location, err := time.LoadLocation(account.Timezone); err != nil {
+			addError("aws[%d]: unknown timezone %q", i, account.Timezone)
+		} else if account.Hourly && !wholeHourOffset(location) {
+			// Points are written by the hour, the hours of the day would be written half an hour off
+			addError("aws[%d]: hourly can not be used with timezone %q that is not a whole hour from UTC", i, account.Timezone)
+		}
+		for _, dimension := range account.Dimensions {
+			if !contains(awsDimensions, dimension) {
+				addError("aws[%d]: unknown dimension %q, must be one of %s", i, dimension, strings.Join(awsDimensions, ", "))
+			}
+		}
+		for _, tag := range account.Tags {
+			if tag == "" {
+				addError("aws[%d]: tags contains an empty tag name", i)
+			} else if contains(awsLabels, tag) || contains(account.Dimensions, tag) {
+				addError("aws[%d]: tag %q has the same name as another label", i, tag)
+			}
+		}
+		if account.Window < 0 {
+			addError("aws[%d]: window must not be negative", i)
+		}
+		// Labels of the line items would be overwritten by the added labels. The Cost Explorer labels by group_by.
+		labels := append(append([]string{}, account.Dimensions...), account.Tags...)
+		if account.Source == AWSSourceCostExplorer {
+			labels = append(labels, account.GroupBy...)
+		}
+		for _, label := range labels {
+			if _, ok := config.Labels.Add[label]; ok {
+				addError("aws[%d]: label %q would be overwritten by labels.add", i, label)
+			}
+		}
+	}
+	for i, tenant := range config.Azure {
+		checkName(CloudAzure, i, tenant.Name)
+		for j, sub := range tenant.Subscriptions {
+			if sub == "" {
+				addError("azure[%d]: subscriptions[%d] is empty", i, j)
+			}
+		}
+		if tenant.Hourly {
+			addError("azure[%d]: hourly is not supported, the consumption API only returns the usage of whole days", i)
+		}
+		if tenant.Timeout < 0 {
+			addError("azure[%d]: timeout must not be negative", i)
+		}
+		if tenant.Window < 0 {
+			addError("azure[%d]: window must not be negative", i)
+		}
+		checkFilter := func(field string, filter SubscriptionFilter) {
+			for _, name := range filter.Names {
+				if _, err := path.Match(name, ""); err != nil {
+					addError("azure[%d]: %s has an invalid name glob %q", i, field, name)
+				}
+			}
+			for _, state := range filter.States {
+				if !containsFold(azureStates, state) {
+					addError("azure[%d]: %s has an unknown state %q, must be one of %s", i, field, state, strings.Join(azureStates, ", "))
+				}
+			}
+		}
+		checkFilter("include", tenant.Include)
+		checkFilter("exclude", tenant.Exclude)
+	}
+
+	if config.Schedule.Cron != "" && config.Schedule.Interval != 0 {
+		addError("schedule: only one of cron and interval can be set")
+	}
+	if config.Schedule.Cron != "" {
+		if _, err := cron.ParseStandard(config.Schedule.Cron); err != nil {
+			addError("schedule: invalid cron expression %q: %v", config.Schedule.Cron, err)
+		}
+	}
+	if config.Schedule.Interval < 0 || (config.Schedule.Interval > 0 && config.Schedule.Interval < time.Second) {
+		addError("schedule: interval must be at least one second")
+	}
+	if config.Schedule.Window < 1 {
+		addError("schedule: window must be at least one day")
+	}
+
+	for key := range config.Labels.Add {
+		if key == "" {
+			addError("labels: add contains an empty label name")
+		}
+	}
+	// The labels that the providers always set would be overwritten as well
+	if len(config.AWS) > 0 {
+		for _, label := range awsLabels {
+			if _, ok := config.Labels.Add[label]; ok {
+				addError("labels: add would overwrite the label %q of aws", label)
+			}
+		}
+	}
+	if len(config.Azure) > 0 {
+		for _, label := range azureLabels {
+			if _, ok := config.Labels.Add[label]; ok {
+				addError("labels: add would overwrite the label %q of azure", label)
+			}
+		}
+	}
+	for _, key := range config.Labels.Drop {
+		if key == "" {
+			addError("labels: drop contains an empty label name")
+		}
+	}
+
+	return errs
+}
+
+// Apply adds and drops labels of the UsageData according to the rules
+func (rules LabelRules) Apply(data []dbclient.UsageData) {
+	for i := range data {
+		if data[i].Labels == nil {
+			data[i].Labels = map[string]string{}
+		}
+		for key, value := range rules.Add {
+			data[i].Labels[key] = value
+		}
+		for _, key := range rules.Drop {
+			delete(data[i].Labels, key)
+		}
+	}
+}
+
+// wholeHourOffset returns true if the time zone is a whole number of hours from UTC,
+// both in the winter and in the summer
+func wholeHourOffset(location *time.Location) bool {
+	year := time.Now().Year()
+	for _, month := range []time.Month{time.January, time.July} {
+		if _, offset := time.Date(year, month, 1, 0, 0, 0, 0, location).Zone(); offset%3600 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// contains returns true if the value is in the list
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold returns true if the value is in the list, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
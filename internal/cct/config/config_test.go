@@ -0,0 +1,397 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lentzi90/cloud-cost-tracker/internal/cct/dbclient"
+)
+
+var exampleConfig = `
+database:
+  address: http://influx:8086
+  name: costs
+clouds: [AWS]
+aws:
+  - name: payer
+    report_name: report
+    bucket: billing
+    report_prefix: daily
+    region: eu-west-1
+    profile: billing
+    role_arn: arn:aws:iam::123456789012:role/billing
+    payer_account: main
+    group_by: [service]
+    strict: true
+    max_row_errors: 5
+    cost_metrics: [amortized, unblended]
+    dimensions: [account]
+    tags: [team]
+    hourly: true
+    timezone: Europe/Stockholm
+azure:
+  - name: tenant
+    tenant_id: abcd
+    subscriptions: [sub1, sub2]
+    write_partial: true
+schedule:
+  interval: 6h
+labels:
+  add:
+    environment: production
+  drop: [instance]
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(exampleConfig))
+	if err != nil {
+		t.Fatalf("Caught error: %s", err)
+	}
+
+	if config.Database.Address != "http://influx:8086" || config.Database.Name != "costs" {
+		t.Errorf("Database not parsed correctly: %+v", config.Database)
+	}
+	// Values that are not in the file are taken from the defaults
+	if config.Database.Username != "cctUser" || config.Schedule.Window != 3 {
+		t.Errorf("Defaults not kept: %+v", config)
+	}
+	if len(config.Clouds) != 1 || config.Clouds[0] != CloudAWS {
+		t.Errorf("Expected clouds [aws], got %v", config.Clouds)
+	}
+
+	expectedAWS := []AWSConfig{{Name: "payer", ReportName: "report", Bucket: "billing", ReportPrefix: "daily", Region: "eu-west-1", Profile: "billing", RoleARN: "arn:aws:iam::123456789012:role/billing", PayerAccount: "main", GroupBy: []string{"service"}, Strict: true, MaxRowErrors: 5, CostMetrics: []string{"amortized", "unblended"}, Dimensions: []string{"account"}, Tags: []string{"team"}, Hourly: true, Timezone: "Europe/Stockholm"}}
+	if !reflect.DeepEqual(config.AWS, expectedAWS) {
+		t.Errorf("Expected aws %+v, got %+v", expectedAWS, config.AWS)
+	}
+	if len(config.Azure) != 1 || config.Azure[0].TenantID != "abcd" || len(config.Azure[0].Subscriptions) != 2 || !config.Azure[0].WritePartial {
+		t.Errorf("Azure not parsed correctly: %+v", config.Azure)
+	}
+	if config.Schedule.Interval != 6*time.Hour {
+		t.Errorf("Expected interval 6h, got %v", config.Schedule.Interval)
+	}
+	if config.Labels.Add["environment"] != "production" || len(config.Labels.Drop) != 1 {
+		t.Errorf("Labels not parsed correctly: %+v", config.Labels)
+	}
+
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Errorf("Expected a valid configuration, got %v", errs)
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	_, err := Parse([]byte("database:\n  adress: http://influx:8086\n"))
+	if err == nil {
+		t.Errorf("Expected error but got none!")
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	env := map[string]string{
+		"CCT_DB_ADDRESS": "http://env:8086",
+		"CCT_CLOUD":      "aws, Azure",
+		"CCT_WINDOW":     "7",
+		"CCT_INTERVAL":   "not a duration",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	config := Default()
+	errs := config.ApplyEnvironment(lookup)
+
+	if len(errs) != 1 {
+		t.Errorf("Expected 1 error, got %v", errs)
+	}
+	if config.Database.Address != "http://env:8086" {
+		t.Errorf("Expected address from environment, got %s", config.Database.Address)
+	}
+	if config.Database.Name != "cloudCostTracker" {
+		t.Errorf("Expected default name, got %s", config.Database.Name)
+	}
+	if len(config.Clouds) != 2 || config.Clouds[1] != CloudAzure {
+		t.Errorf("Expected clouds [aws azure], got %v", config.Clouds)
+	}
+	if config.Schedule.Window != 7 {
+		t.Errorf("Expected window 7, got %d", config.Schedule.Window)
+	}
+}
+
+func TestValidateDefault(t *testing.T) {
+	config := Default()
+	if len(config.AWS) != 0 || len(config.Azure) != 0 {
+		t.Errorf("Expected no cloud accounts by default, got %+v and %+v", config.AWS, config.Azure)
+	}
+	// Nothing is fetched unless it is configured
+	if errs := config.Validate(); len(errs) != 1 {
+		t.Errorf("Expected 1 problem without cloud accounts, got %v", errs)
+	}
+
+	config.Azure = []AzureConfig{{Name: CloudAzure}}
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Errorf("Expected the default configuration with a tenant to be valid, got %v", errs)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	config := Default()
+	config.Database.Address = "localhost"
+	config.Clouds = []string{"gcp"}
+	config.AWS = []AWSConfig{{Name: CloudAWS, ReportName: "report"}}
+	config.AWS = append(config.AWS, AWSConfig{Name: CloudAWS, ReportPrefix: "daily-report", RoleARN: "billing", MaxRowErrors: -1, CostMetrics: []string{"list"}, Timezone: "Mars/Olympus", Dimensions: []string{"zone"}, Tags: []string{"service"}})
+	config.Schedule = ScheduleConfig{Cron: "every day", Interval: time.Hour, Window: 0}
+
+	// One problem for each of: address, cloud, duplicate name, report name,
+	// report prefix without bucket, role ARN, max row errors, cost metric, timezone, dimension, tag, cron and interval,
+	// cron expression and window
+	expected := 14
+	errs := config.Validate()
+	if len(errs) != expected {
+		t.Errorf("Expected %d problems, got %d: %v", expected, len(errs), errs)
+	}
+}
+
+func TestValidateDirectory(t *testing.T) {
+	config := Default()
+	config.AWS = []AWSConfig{{Name: CloudAWS, Directory: "reports"}}
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Errorf("Expected a valid configuration without report_name, got %v", errs)
+	}
+
+	config.AWS[0].Bucket = "billing"
+	if errs := config.Validate(); len(errs) != 1 {
+		t.Errorf("Expected 1 problem with both directory and bucket, got %v", errs)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	cases := []struct {
+		name    string
+		account AWSConfig
+		valid   bool
+	}{
+		{"Static keys", AWSConfig{AccessKeyID: "id", SecretAccessKey: "secret", Endpoint: "http://localhost:9000"}, true},
+		{"Web identity", AWSConfig{RoleARN: "arn:aws:iam::123456789012:role/cct", WebIdentityTokenFile: "/var/run/secrets/token"}, true},
+		{"Assume role with external ID", AWSConfig{SecretsFile: "/run/secrets/aws", RoleARN: "arn:aws:iam::123456789012:role/cct", ExternalID: "cct"}, true},
+		{"Secret access key only", AWSConfig{SecretAccessKey: "secret"}, false},
+		{"Static keys and secrets file", AWSConfig{AccessKeyID: "id", SecretAccessKey: "secret", SecretsFile: "/run/secrets/aws"}, false},
+		{"External ID without role", AWSConfig{ExternalID: "cct"}, false},
+		{"Endpoint without scheme", AWSConfig{Endpoint: "localhost:9000"}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := Default()
+			c.account.Name = CloudAWS
+			c.account.ReportName = "report"
+			config.AWS = []AWSConfig{c.account}
+			if errs := config.Validate(); (len(errs) == 0) != c.valid {
+				t.Errorf("Expected valid %t but got %v", c.valid, errs)
+			}
+		})
+	}
+}
+
+func TestValidateHourlyTimezone(t *testing.T) {
+	cases := []struct {
+		timezone string
+		hourly   bool
+		valid    bool
+	}{
+		{"Europe/Stockholm", true, true},
+		{"Asia/Kolkata", false, true},
+		{"Asia/Kolkata", true, false},
+		{"Australia/Adelaide", true, false},
+	}
+
+	for _, c := range cases {
+		config := Default()
+		config.AWS = []AWSConfig{{Name: CloudAWS, ReportName: "report", Timezone: c.timezone, Hourly: c.hourly}}
+		if errs := config.Validate(); (len(errs) == 0) != c.valid {
+			t.Errorf("Expected valid %t for %s with hourly %t but got %v", c.valid, c.timezone, c.hourly, errs)
+		}
+	}
+}
+
+func TestValidateLabelsAdd(t *testing.T) {
+	config := Default()
+	config.AWS = []AWSConfig{{Name: CloudAWS, ReportName: "report", Dimensions: []string{"account"}, Tags: []string{"team"}}}
+	config.Labels.Add = map[string]string{"environment": "production"}
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Errorf("Expected a valid configuration, got %v", errs)
+	}
+
+	config.Labels.Add = map[string]string{"team": "platform", "account": "main"}
+	if errs := config.Validate(); len(errs) != 2 {
+		t.Errorf("Expected 2 problems with the tag and dimension, got %v", errs)
+	}
+}
+
+func TestValidateLabelsAddReserved(t *testing.T) {
+	cases := []struct {
+		name  string
+		label string
+		aws   bool
+		azure bool
+		valid bool
+	}{
+		{"Service of AWS", "service", true, false, false},
+		{"Line item type of AWS", "line_item_type", true, false, false},
+		{"Payer account of AWS", "payer_account", true, false, false},
+		{"Payer account without AWS", "payer_account", false, true, true},
+		{"Currency of Azure", "currency", false, true, false},
+		{"Resource group of Azure", "resource_group", false, true, false},
+		{"Parent of Azure", "parent", false, true, false},
+		{"Resource group without Azure", "resource_group", true, false, true},
+		{"Not reserved", "environment", true, true, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := Default()
+			if c.aws {
+				config.AWS = []AWSConfig{{Name: CloudAWS, ReportName: "report"}}
+			}
+			if c.azure {
+				config.Azure = []AzureConfig{{Name: CloudAzure}}
+			}
+			config.Labels.Add = map[string]string{c.label: "value"}
+			if errs := config.Validate(); (len(errs) == 0) != c.valid {
+				t.Errorf("Expected valid %t but got %v", c.valid, errs)
+			}
+		})
+	}
+}
+
+func TestValidateCostExplorer(t *testing.T) {
+	config := Default()
+	config.AWS = []AWSConfig{{Name: CloudAWS, Source: AWSSourceCostExplorer, GroupBy: []string{"service", "team"}}}
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Errorf("Expected a valid configuration without report_name, got %v", errs)
+	}
+
+	config.AWS[0].GroupBy = append(config.AWS[0].GroupBy, "account")
+	config.AWS[0].CostMetrics = []string{"public_on_demand"}
+	config.AWS[0].Bucket = "billing"
+	if errs := config.Validate(); len(errs) != 3 {
+		t.Errorf("Expected 3 problems with group_by, cost_metrics and bucket, got %v", errs)
+	}
+
+	// Options of the report that the Cost Explorer would ignore
+	ignored := []AWSConfig{
+		{Timezone: "Europe/Stockholm"},
+		{Dimensions: []string{"account"}},
+		{Tags: []string{"team"}},
+		{Strict: true},
+		{MaxRowErrors: 5},
+		{GroupBy: []string{"resource"}},
+		{GroupBy: []string{"line_item_type"}},
+	}
+	for _, account := range ignored {
+		account.Name = CloudAWS
+		account.Source = AWSSourceCostExplorer
+		config.AWS = []AWSConfig{account}
+		if errs := config.Validate(); len(errs) != 1 {
+			t.Errorf("Expected 1 problem with %+v, got %v", account, errs)
+		}
+	}
+
+	config.AWS[0].Source = "athena"
+	if errs := config.Validate(); len(errs) == 0 {
+		t.Errorf("Expected a problem with an unknown source but got none!")
+	}
+}
+
+func TestValidateSubscriptionFilters(t *testing.T) {
+	cases := []struct {
+		name   string
+		tenant AzureConfig
+		valid  bool
+	}{
+		{"Names and states", AzureConfig{Include: SubscriptionFilter{Names: []string{"team-a-*"}, States: []string{"warned"}}, Exclude: SubscriptionFilter{IDs: []string{"abcd"}}}, true},
+		{"Invalid glob", AzureConfig{Exclude: SubscriptionFilter{Names: []string{"team-[a"}}}, false},
+		{"Unknown state", AzureConfig{Include: SubscriptionFilter{States: []string{"Active"}}}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := Default()
+			c.tenant.Name = CloudAzure
+			config.Azure = []AzureConfig{c.tenant}
+			if errs := config.Validate(); (len(errs) == 0) != c.valid {
+				t.Errorf("Expected valid %t but got %v", c.valid, errs)
+			}
+		})
+	}
+}
+
+func TestValidateAzureHourly(t *testing.T) {
+	config := Default()
+	config.Azure = []AzureConfig{{Name: CloudAzure, Hourly: true}}
+	errs := config.Validate()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "hourly") {
+		t.Errorf("Expected 1 problem with hourly, got %v", errs)
+	}
+}
+
+func TestCloudEnabled(t *testing.T) {
+	cases := []struct {
+		clouds []string
+		cloud  string
+		want   bool
+	}{
+		{nil, CloudAWS, true},
+		{[]string{CloudAll}, CloudAzure, true},
+		{[]string{CloudAWS}, CloudAWS, true},
+		{[]string{CloudAWS}, CloudAzure, false},
+	}
+
+	for _, c := range cases {
+		config := Config{Clouds: c.clouds}
+		if actual := config.CloudEnabled(c.cloud); actual != c.want {
+			t.Errorf("CloudEnabled(%s) with clouds %v: expected %t, got %t", c.cloud, c.clouds, c.want, actual)
+		}
+	}
+}
+
+func TestLabelRulesApply(t *testing.T) {
+	rules := LabelRules{Add: map[string]string{"team": "platform"}, Drop: []string{"instance"}}
+	data := []dbclient.UsageData{
+		{Cost: 1, Labels: map[string]string{"instance": "vm1", "cloud": "azure"}},
+		{Cost: 2},
+	}
+
+	rules.Apply(data)
+
+	expected := []map[string]string{
+		{"cloud": "azure", "team": "platform"},
+		{"team": "platform"},
+	}
+	for i := range expected {
+		if len(data[i].Labels) != len(expected[i]) {
+			t.Errorf("Expected labels %v, got %v", expected[i], data[i].Labels)
+		}
+		for k, v := range expected[i] {
+			if data[i].Labels[k] != v {
+				t.Errorf("Expected: %s=%s, actual: %s=%s", k, v, k, data[i].Labels[k])
+			}
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	config := Default()
+	config.AWS = []AWSConfig{{Name: CloudAWS, ReportName: "report", Window: 5}}
+	config.Azure = []AzureConfig{{Name: CloudAzure, TenantID: "tenant", Window: 0}}
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Errorf("Expected a valid configuration, got %v", errs)
+	}
+
+	config.AWS[0].Window = -1
+	config.Azure[0].Window = -1
+	if errs := config.Validate(); len(errs) != 2 {
+		t.Errorf("Expected 2 problems with the windows, got %v", errs)
+	}
+}
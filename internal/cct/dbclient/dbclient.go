@@ -3,6 +3,7 @@ package dbclient
 //go:generate mockgen -destination=./dbclient_mock.go -package=dbclient -source=dbclient.go
 
 import (
+	"context"
 	"time"
 
 	client "github.com/influxdata/influxdb/client/v2"
@@ -10,14 +11,20 @@ import (
 
 // UsageData Struct that the submodules should return
 type UsageData struct {
-	Cost   float64
-	Date   time.Time
+	Cost float64
+	Date time.Time
+	// Labels are written as tags and should only have few distinct values, since every combination is a new series
 	Labels map[string]string
+	// Fields are optional values that are written next to the cost, e.g. usage quantity
+	Fields map[string]float64
+	// Attributes are optional strings that are written as fields instead of tags, e.g. unique identifiers
+	Attributes map[string]string
 }
 
-// CloudCostClient The interface that all the cloudClients should implement
+// CloudCostClient The interface that all the cloudClients should implement.
+// GetCloudCost should stop and return the error of the context when it is done.
 type CloudCostClient interface {
-	GetCloudCost(time.Time) ([]UsageData, error)
+	GetCloudCost(context.Context, time.Time) ([]UsageData, error)
 }
 
 // Config struct with connection information of the influxDB
@@ -141,10 +148,17 @@ func (e *DBClient) createBatchPoints(data UsageData) (bp, error) {
 	}
 
 	// Convert decimal to float and add as field
-	cost := map[string]interface{}{"cost": data.Cost}
+	fields := map[string]interface{}{}
+	for key, value := range data.Attributes {
+		fields[key] = value
+	}
+	for key, value := range data.Fields {
+		fields[key] = value
+	}
+	fields["cost"] = data.Cost
 
 	// Create and add point
-	pt, err := e.influxInterface.NewPoint("cost", data.Labels, cost, data.Date)
+	pt, err := e.influxInterface.NewPoint("cost", data.Labels, fields, data.Date)
 	if err != nil {
 		return nil, err
 	}
@@ -123,6 +123,53 @@ func TestAddUsageData(t *testing.T) {
 	}
 }
 
+// Tests that the optional fields are written next to the cost
+func TestAddUsageDataFields(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testPoint := &client.Point{}
+	mockedBP := NewMockbp(mockCtrl)
+	mockedBP.EXPECT().AddPoint(testPoint).Times(1)
+
+	mockConnection := createWorkingConClient(mockCtrl, 2, 1)
+	mockinfluxInterface := createWorkinginfluxInterface(mockCtrl, mockConnection)
+
+	mockinfluxInterface.EXPECT().NewBatchPoints(client.BatchPointsConfig{
+		Database:  dbConfig.DBName,
+		Precision: "h",
+	}).
+		Times(1).
+		DoAndReturn(func(conf client.BatchPointsConfig) (client.BatchPoints, error) {
+			return mockedBP, nil
+		})
+
+	data := UsageData{
+		Cost:   444,
+		Date:   time.Date(2004, time.April, 4, 4, 0, 0, 0, time.UTC),
+		Labels: map[string]string{"currency": "USD"},
+		Fields: map[string]float64{"usage_quantity": 24, "line_items": 2},
+		// Identifiers are written as fields and not as tags
+		Attributes: map[string]string{"line_item_id": "abc"},
+	}
+	expectedFields := map[string]interface{}{"cost": data.Cost, "usage_quantity": 24.0, "line_items": 2.0, "line_item_id": "abc"}
+
+	mockinfluxInterface.EXPECT().NewPoint("cost", data.Labels, expectedFields, data.Date).
+		Times(1).
+		DoAndReturn(func(name string, tags map[string]string, fields map[string]interface{}, t time.Time) (*client.Point, error) {
+			return testPoint, nil
+		})
+
+	dbClient := NewDBClient(dbConfig)
+	dbClient.influxInterface = mockinfluxInterface
+
+	actual := dbClient.AddUsageData([]UsageData{data})
+
+	if actual != nil {
+		t.Errorf("Wanted: AddUsageData to return nil but got %v", actual)
+	}
+}
+
 // Tests that AddUsageData fails if httpClient fails
 func TestHttpClientFail(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
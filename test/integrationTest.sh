@@ -86,7 +86,7 @@ startDBAndCheckIfEmpty(){
 runTest(){
     logInfo "Fetching data for $1"
     # Fetch Data
-    go run ../cmd/cct/main.go \
+    go run ../cmd/cct \
         --cloud $1 \
         --db-address http://$DATABASEHOST:$DATABASEPORT \
         --db-name $DATABASE \